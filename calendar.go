@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// calendarRepeats is how many times a repeating reminder is put in a calendar. Calendar apps
+// keep checking feeds, so later ones turn up as earlier ones go by
+const calendarRepeats = 10
+
+// icsEvent is one event in a calendar. All-day events only use Start's date
+type icsEvent struct {
+	UID         string
+	Start       time.Time
+	AllDay      bool
+	Summary     string
+	Description string
+	URL         string
+}
+
+// buildCalendar puts someone's pending reminders in a guild, along with the prompts for the
+// music month running at now, into an iCalendar file
+func buildCalendar(ctx context.Context, guildID, userID string, now time.Time) ([]byte, error) {
+	pending, err := pendingReminders(ctx, guildID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("getting reminders: %v", err)
+	}
+	var events []icsEvent
+	for _, r := range pending {
+		if !r.Dead {
+			events = append(events, reminderEvents(r)...)
+		}
+	}
+	m, err := activeMonth(ctx, guildID, now)
+	if err != nil && err != errNotFound {
+		return nil, fmt.Errorf("getting music month: %v", err)
+	}
+	if m != nil {
+		events = append(events, promptEvents(*m)...)
+	}
+	return writeICS(conf.guild(guildID).Community+" reminders", now, events), nil
+}
+
+// reminderEvents gets the next few times a reminder goes off
+func reminderEvents(r reminder) []icsEvent {
+	var events []icsEvent
+	for len(events) < calendarRepeats {
+		event := icsEvent{
+			UID:     r.ID + "-" + strconv.FormatInt(r.Date.Unix(), 10) + "@kazooiebot",
+			Start:   r.Date,
+			Summary: r.Reminder,
+		}
+		if r.AboutMessageID != "" {
+			event.URL = aboutLink(r)
+			event.Description = r.Quote
+		}
+		events = append(events, event)
+
+		// Move it on the same way delivering it would
+		next, again := nextReminder(r, r.Date)
+		if !again {
+			break
+		}
+		if !r.Snoozed {
+			r.Sent++
+		}
+		r.Snoozed = false
+		r.Date = next
+	}
+	return events
+}
+
+// promptEvents makes an all-day event for each of a music month's prompts
+func promptEvents(m month) []icsEvent {
+	events := make([]icsEvent, 0, len(m.Days))
+	for _, d := range m.Days {
+		date := m.StartTime.AddDate(0, 0, d.Day-1)
+		events = append(events, icsEvent{
+			UID:     "music-" + m.GuildID + "-" + date.Format("20060102") + "@kazooiebot",
+			Start:   date,
+			AllDay:  true,
+			Summary: "Music month day " + strconv.Itoa(d.Day) + ": " + d.Prompt,
+		})
+	}
+	return events
+}
+
+// writeICS writes events out as an iCalendar file, as RFC 5545 has it
+func writeICS(name string, now time.Time, events []icsEvent) []byte {
+	var b bytes.Buffer
+	line := func(text string) {
+		b.WriteString(foldICS(text))
+		b.WriteString("\r\n")
+	}
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//kazooiebot//calendar//EN")
+	line("CALSCALE:GREGORIAN")
+	line("X-WR-CALNAME:" + escapeICS(name))
+	stamp := now.UTC().Format("20060102T150405Z")
+	for _, e := range events {
+		line("BEGIN:VEVENT")
+		line("UID:" + e.UID)
+		line("DTSTAMP:" + stamp)
+		if e.AllDay {
+			line("DTSTART;VALUE=DATE:" + e.Start.Format("20060102"))
+			line("DTEND;VALUE=DATE:" + e.Start.AddDate(0, 0, 1).Format("20060102"))
+		} else {
+			line("DTSTART:" + e.Start.UTC().Format("20060102T150405Z"))
+		}
+		line("SUMMARY:" + escapeICS(e.Summary))
+		if e.Description != "" {
+			line("DESCRIPTION:" + escapeICS(e.Description))
+		}
+		if e.URL != "" {
+			line("URL:" + e.URL)
+		}
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	return b.Bytes()
+}
+
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// escapeICS escapes text for a property value
+func escapeICS(text string) string {
+	return icsEscaper.Replace(text)
+}
+
+// foldICS splits a line longer than 75 bytes onto continuation lines, which start with a
+// space, without splitting a character
+func foldICS(text string) string {
+	var b strings.Builder
+	limit := 75
+	for len(text) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		b.WriteString(text[:cut] + "\r\n ")
+		text = text[cut:]
+		// Continuation lines lose a byte to the space
+		limit = 74
+	}
+	b.WriteString(text)
+	return b.String()
+}
+
+// newCalendarToken makes a token for a feed's URL, returning it and the feed ID it's saved under
+func newCalendarToken() (string, string, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(raw)
+	return token, calendarFeedID(token), nil
+}
+
+// calendarFeedID is the ID a feed is saved under for a token
+func calendarFeedID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+var calendarCommand = &command{
+	Name:        "calendar",
+	Description: "Get your reminders and this month's music prompts in your calendar",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "download",
+			Description: "Get a calendar file to import",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "subscribe",
+			Description: "Get a link your calendar app can keep checking. Asking again stops the old link working",
+		},
+	},
+	Handler: calendarHandler,
+}
+
+func calendarHandler(ctx context.Context, s botSession, i *discordgo.InteractionCreate, opts commandOptions) {
+	respond := func(content string, files ...*discordgo.File) {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags:   64,
+				Content: content,
+				Files:   files,
+			},
+		})
+	}
+	if db == nil {
+		respond("I haven't been set up to remember anything, please moan at whoever set me up")
+		return
+	}
+	userID := i.Member.User.ID
+
+	if opts.Subcommand() == "download" {
+		data, err := buildCalendar(ctx, i.GuildID, userID, time.Now().In(userLocation(ctx, s, i)))
+		if err != nil {
+			respond("Something went wrong at my end so I couldn't make your calendar")
+			report(s, i, fmt.Errorf("making calendar: %v", err))
+			return
+		}
+		respond("Here's your calendar. Open it or import it into your calendar app", &discordgo.File{
+			Name:        "reminders.ics",
+			ContentType: "text/calendar",
+			Reader:      bytes.NewReader(data),
+		})
+		return
+	}
+
+	if conf.Calendar.Listen == "" {
+		respond("Calendar links aren't turned on here, but /calendar download gets you a file")
+		return
+	}
+	token, id, err := newCalendarToken()
+	if err == nil {
+		err = db.SaveCalendarFeed(ctx, calendarFeed{ID: id, GuildID: i.GuildID, UserID: userID})
+	}
+	if err != nil {
+		respond("Something went wrong at my end so I couldn't make you a link")
+		report(s, i, fmt.Errorf("saving calendar feed: %v", err))
+		return
+	}
+	respond("Subscribe to this in your calendar app: " + conf.calendarBaseURL() + "/calendar/" + token + ".ics\n" +
+		"Keep it to yourself, since anyone with it can see your reminders. Asking again gives you a new link and stops this one working")
+}
+
+// serveCalendars serves calendar feeds at /calendar/<token>.ics until ctx is cancelled
+func serveCalendars(ctx context.Context, addr string) {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           http.HandlerFunc(calendarFeedHandler),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("Calendar feeds stopped: %v", err)
+	}
+}
+
+func calendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/calendar/"), ".ics")
+	if !strings.HasPrefix(r.URL.Path, "/calendar/") || token == "" || strings.Contains(token, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	feed, err := db.CalendarFeed(r.Context(), calendarFeedID(token))
+	if err == errNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Couldn't get calendar feed: %v", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	loc, err := savedLocation(r.Context(), feed.UserID)
+	if err != nil {
+		log.Printf("Calendar feed for %v: %v", feed.UserID, err)
+	}
+	data, err := buildCalendar(r.Context(), feed.GuildID, feed.UserID, time.Now().In(loc))
+	if err != nil {
+		log.Printf("Couldn't make calendar feed for %v: %v", feed.UserID, err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write(data)
+}
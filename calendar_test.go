@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestWriteICS(t *testing.T) {
+	now := time.Date(2026, 10, 16, 14, 30, 0, 0, time.UTC)
+	long := strings.Repeat("é", 50)
+	got := string(writeICS("Test, calendar", now, []icsEvent{
+		{UID: "a@kazooiebot", Start: time.Date(2026, 10, 17, 9, 0, 0, 0, time.FixedZone("BST", 3600)), Summary: "feed the bird; then\nthe cat\rthe dog"},
+		{UID: "b@kazooiebot", Start: time.Date(2026, 10, 31, 0, 0, 0, 0, time.UTC), AllDay: true, Summary: long},
+	}))
+
+	for _, want := range []string{
+		"X-WR-CALNAME:Test\\, calendar\r\n",
+		"DTSTAMP:20261016T143000Z\r\n",
+		"DTSTART:20261017T080000Z\r\nSUMMARY:feed the bird\\; then\\nthe cat\\nthe dog\r\n",
+		"DTSTART;VALUE=DATE:20261031\r\nDTEND;VALUE=DATE:20261101\r\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Calendar doesn't contain %q:\n%v", want, got)
+		}
+	}
+	for _, line := range strings.Split(got, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("Line is %d bytes: %q", len(line), line)
+		}
+	}
+	// Unfolding gets the long summary back in one piece
+	if unfolded := strings.Replace(got, "\r\n ", "", -1); !strings.Contains(unfolded, "SUMMARY:"+long+"\r\n") {
+		t.Errorf("Long summary was mangled:\n%v", got)
+	}
+}
+
+func TestCalendarDownload(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	db.AddMonth(ctx, month{GuildID: "1", StartTime: start, Days: []day{{Day: 1, Prompt: "birds"}, {Day: 2, Prompt: "bears"}}})
+	soon := now.Add(time.Hour).Truncate(time.Second)
+	db.AddReminder(ctx, reminder{GuildID: "1", UserID: "100", Reminder: "feed the bird", Date: soon, Repeat: "@every 24h", Times: 3})
+	db.AddReminder(ctx, reminder{GuildID: "1", UserID: "100", Reminder: "given up on", Date: soon, Dead: true})
+	db.AddReminder(ctx, reminder{GuildID: "1", UserID: "101", Reminder: "someone else's", Date: soon})
+
+	fake := newFakeSession()
+	handleInteraction(ctx, fake, slashCommand("calendar", &discordgo.ApplicationCommandInteractionDataOption{Name: "download", Type: discordgo.ApplicationCommandOptionSubCommand}))
+	if len(fake.responses) != 1 || len(fake.responses[0].Data.Files) != 1 {
+		t.Fatalf("Got responses %+v, want a file", fake.responses)
+	}
+	file := fake.responses[0].Data.Files[0]
+	data, _ := ioutil.ReadAll(file.Reader)
+	got := string(data)
+	if file.Name != "reminders.ics" || strings.Count(got, "BEGIN:VEVENT") != 5 {
+		t.Fatalf("Got %v with %d events, want 3 reminders and 2 prompts:\n%v", file.Name, strings.Count(got, "BEGIN:VEVENT"), got)
+	}
+	for _, want := range []string{
+		"DTSTART:" + soon.Add(48*time.Hour).Format("20060102T150405Z") + "\r\nSUMMARY:feed the bird\r\n",
+		"DTSTART;VALUE=DATE:" + start.AddDate(0, 0, 1).Format("20060102") + "\r\n",
+		"SUMMARY:Music month day 2: bears\r\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Calendar doesn't contain %q:\n%v", want, got)
+		}
+	}
+	if strings.Contains(got, "given up on") || strings.Contains(got, "someone else's") {
+		t.Errorf("Calendar has reminders it shouldn't:\n%v", got)
+	}
+}
+
+func TestCalendarSubscribe(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	subscribe := func() string {
+		fake := newFakeSession()
+		handleInteraction(ctx, fake, slashCommand("calendar", &discordgo.ApplicationCommandInteractionDataOption{Name: "subscribe", Type: discordgo.ApplicationCommandOptionSubCommand}))
+		return fake.responses[0].Data.Content
+	}
+	if got := subscribe(); got != "Calendar links aren't turned on here, but /calendar download gets you a file" {
+		t.Errorf("Got %q with feeds off", got)
+	}
+
+	conf.Calendar.Listen = "localhost:8080"
+	conf.Calendar.BaseURL = "https://kazooie.example.com/"
+	link := regexp.MustCompile(`https://kazooie\.example\.com(/calendar/[0-9a-f]+\.ics)`)
+	fetch := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		calendarFeedHandler(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	first := link.FindStringSubmatch(subscribe())
+	if first == nil {
+		t.Fatal("No link given")
+	}
+	w := fetch(first[1])
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/calendar; charset=utf-8" || !strings.HasPrefix(w.Body.String(), "BEGIN:VCALENDAR\r\n") {
+		t.Errorf("Feed got %v %q", w.Code, w.Body.String())
+	}
+
+	// A new link replaces the old one
+	second := link.FindStringSubmatch(subscribe())
+	if second == nil || second[1] == first[1] {
+		t.Fatalf("Got links %q then %q", first, second)
+	}
+	if w := fetch(first[1]); w.Code != http.StatusNotFound {
+		t.Errorf("Old link got %v", w.Code)
+	}
+	if w := fetch(second[1]); w.Code != http.StatusOK {
+		t.Errorf("New link got %v", w.Code)
+	}
+	if w := fetch("/calendar/nope.ics"); w.Code != http.StatusNotFound {
+		t.Errorf("Made up link got %v", w.Code)
+	}
+}
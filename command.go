@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type commandHandler func(ctx context.Context, s botSession, i *discordgo.InteractionCreate, opts commandOptions)
+
+// command is everything about a slash command: what Discord is told about it and what
+// happens when it's used
+type command struct {
+	// Type is a slash command unless it's set to one of the context menu types, which show up
+	// when right-clicking a user or message. Those have no description or options
+	Type        discordgo.ApplicationCommandType
+	Name        string
+	Description string
+	Options     []*discordgo.ApplicationCommandOption
+	Handler     commandHandler
+	// Autocomplete suggests values for options declared with Autocomplete set, using
+	// opts.Focused to tell which one is being typed in
+	Autocomplete func(ctx context.Context, i *discordgo.InteractionCreate, opts commandOptions) []*discordgo.ApplicationCommandOptionChoice
+	// Timeout is how long the handler has before its context runs out, defaulting to
+	// defaultTimeout. Anything that takes longer than Discord's three seconds should start
+	// with deferResponse
+	Timeout time.Duration
+}
+
+func (c *command) applicationCommand() *discordgo.ApplicationCommand {
+	// Everything is per guild, so global commands mustn't turn up in DMs
+	noDMs := false
+	return &discordgo.ApplicationCommand{
+		Type:         c.Type,
+		Name:         c.Name,
+		Description:  c.Description,
+		Options:      c.Options,
+		DMPermission: &noDMs,
+	}
+}
+
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// commandOptions are the options an interaction was sent with, looked up by name. Asking for
+// an option the command doesn't declare, or as the wrong type, is a bug and panics
+type commandOptions struct {
+	command    string
+	subcommand string
+	focused    string
+	declared   []*discordgo.ApplicationCommandOption
+	given      map[string]*discordgo.ApplicationCommandInteractionDataOption
+	resolved   *discordgo.ApplicationCommandInteractionDataResolved
+}
+
+// parseOptions checks an interaction's options against what the command declares, so
+// handlers can rely on every option having the right type and, if strict, on required
+// options being there
+func parseOptions(c *command, data discordgo.ApplicationCommandInteractionData, strict bool) (commandOptions, error) {
+	opts := commandOptions{
+		command:  c.Name,
+		declared: c.Options,
+		given:    map[string]*discordgo.ApplicationCommandInteractionDataOption{},
+		resolved: data.Resolved,
+	}
+	given := data.Options
+	// Subcommands in a group are named with the group, like "prompt set"
+	if len(given) == 1 && given[0].Type == discordgo.ApplicationCommandOptionSubCommandGroup {
+		group := declaredOption(c.Options, given[0].Name)
+		if group == nil || group.Type != discordgo.ApplicationCommandOptionSubCommandGroup {
+			return opts, fmt.Errorf("there's no %q subcommand group", given[0].Name)
+		}
+		opts.subcommand = group.Name + " "
+		opts.declared = group.Options
+		given = given[0].Options
+	}
+	if len(given) == 1 && given[0].Type == discordgo.ApplicationCommandOptionSubCommand {
+		sub := declaredOption(opts.declared, given[0].Name)
+		if sub == nil || sub.Type != discordgo.ApplicationCommandOptionSubCommand {
+			return opts, fmt.Errorf("there's no %q subcommand", strings.TrimSpace(opts.subcommand+given[0].Name))
+		}
+		opts.subcommand += sub.Name
+		opts.declared = sub.Options
+		given = given[0].Options
+	}
+
+	for _, o := range given {
+		d := declaredOption(opts.declared, o.Name)
+		if d == nil {
+			return opts, fmt.Errorf("there's no %q option", o.Name)
+		}
+		if d.Type != o.Type {
+			return opts, fmt.Errorf("%q should be a %v, not a %v", o.Name, d.Type, o.Type)
+		}
+		opts.given[o.Name] = o
+		if o.Focused {
+			opts.focused = o.Name
+		}
+	}
+	if !strict {
+		return opts, nil
+	}
+	for _, d := range opts.declared {
+		if _, ok := opts.given[d.Name]; d.Required && !ok {
+			return opts, fmt.Errorf("%q is required", d.Name)
+		}
+	}
+	return opts, nil
+}
+
+func declaredOption(declared []*discordgo.ApplicationCommandOption, name string) *discordgo.ApplicationCommandOption {
+	for _, d := range declared {
+		if d.Name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+// lookup gets an option by name, or nil if it was left out
+func (o commandOptions) lookup(name string, t discordgo.ApplicationCommandOptionType) *discordgo.ApplicationCommandInteractionDataOption {
+	d := declaredOption(o.declared, name)
+	if d == nil || d.Type != t {
+		panic(fmt.Sprintf("%v has no %v option called %q", strings.TrimSpace("/"+o.command+" "+o.subcommand), t, name))
+	}
+	return o.given[name]
+}
+
+// Subcommand is the subcommand used, if the command has them
+func (o commandOptions) Subcommand() string {
+	return o.subcommand
+}
+
+// Focused is the option being typed in, for autocomplete
+func (o commandOptions) Focused() string {
+	return o.focused
+}
+
+// Has is true if an option was given
+func (o commandOptions) Has(name string) bool {
+	_, ok := o.given[name]
+	return ok
+}
+
+func (o commandOptions) String(name string) string {
+	if v := o.lookup(name, discordgo.ApplicationCommandOptionString); v != nil {
+		return v.StringValue()
+	}
+	return ""
+}
+
+func (o commandOptions) Int(name string) int {
+	if v := o.lookup(name, discordgo.ApplicationCommandOptionInteger); v != nil {
+		return int(v.IntValue())
+	}
+	return 0
+}
+
+func (o commandOptions) Bool(name string) bool {
+	if v := o.lookup(name, discordgo.ApplicationCommandOptionBoolean); v != nil {
+		return v.BoolValue()
+	}
+	return false
+}
+
+// Role gets the full role for a role option, as the option itself only carries the ID
+func (o commandOptions) Role(name string) *discordgo.Role {
+	v := o.lookup(name, discordgo.ApplicationCommandOptionRole)
+	if v == nil {
+		return nil
+	}
+	role := v.RoleValue(nil, "")
+	if o.resolved != nil {
+		if r, ok := o.resolved.Roles[role.ID]; ok {
+			return r
+		}
+	}
+	return role
+}
+
+// User gets the full user for a user option, as the option itself only carries the ID
+func (o commandOptions) User(name string) *discordgo.User {
+	v := o.lookup(name, discordgo.ApplicationCommandOptionUser)
+	if v == nil {
+		return nil
+	}
+	user := v.UserValue(nil)
+	if o.resolved != nil {
+		if u, ok := o.resolved.Users[user.ID]; ok {
+			return u
+		}
+	}
+	return user
+}
+
+// Channel gets the channel for a channel option, as far as Discord sent it
+func (o commandOptions) Channel(name string) *discordgo.Channel {
+	v := o.lookup(name, discordgo.ApplicationCommandOptionChannel)
+	if v == nil {
+		return nil
+	}
+	channel := v.ChannelValue(nil)
+	if o.resolved != nil {
+		if c, ok := o.resolved.Channels[channel.ID]; ok {
+			return c
+		}
+	}
+	return channel
+}
+
+// Attachment gets the file uploaded for an attachment option
+func (o commandOptions) Attachment(name string) *discordgo.MessageAttachment {
+	v := o.lookup(name, discordgo.ApplicationCommandOptionAttachment)
+	if v == nil || o.resolved == nil {
+		return nil
+	}
+	return o.resolved.Attachments[v.Value.(string)]
+}
+
+// Mentionable gets the user or role picked for a mentionable option. Only one is set, or
+// neither if it was left out
+func (o commandOptions) Mentionable(name string) (*discordgo.User, *discordgo.Role) {
+	v := o.lookup(name, discordgo.ApplicationCommandOptionMentionable)
+	if v == nil {
+		return nil, nil
+	}
+	id := v.Value.(string)
+	if o.resolved != nil {
+		if r, ok := o.resolved.Roles[id]; ok {
+			return nil, r
+		}
+		if u, ok := o.resolved.Users[id]; ok {
+			return u, nil
+		}
+	}
+	// Discord always resolves these, so this is just in case
+	return &discordgo.User{ID: id}, nil
+}
+
+// runCommand sends a command interaction to its handler, or tells the user what was wrong
+// with it if Discord let through something the command doesn't expect
+func runCommand(ctx context.Context, s botSession, i *discordgo.InteractionCreate) {
+	data := i.ApplicationCommandData()
+	c := findCommand(data.Name)
+	if c == nil {
+		log.Printf("Got unknown command %q", data.Name)
+		return
+	}
+	opts, err := parseOptions(c, data, true)
+	if err != nil {
+		log.Printf("Bad options for /%v: %v", c.Name, err)
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags:   64,
+				Content: "That doesn't look right: " + err.Error() + ". Discord may still be catching up with a change to the command, so try again in a bit",
+			},
+		})
+		return
+	}
+	c.Handler(ctx, s, i, opts)
+}
+
+var commandName = regexp.MustCompile(`^[-_a-z0-9]{1,32}$`)
+
+// checkCommands catches definitions Discord would reject, before trying to register them
+func checkCommands(cmds []*command) error {
+	var problems []string
+	seen := map[string]bool{}
+	for _, c := range cmds {
+		if seen[c.Name] {
+			problems = append(problems, fmt.Sprintf("/%v is defined twice", c.Name))
+		}
+		seen[c.Name] = true
+		if c.Handler == nil {
+			problems = append(problems, fmt.Sprintf("/%v has no handler", c.Name))
+		}
+		if c.Type == discordgo.UserApplicationCommand || c.Type == discordgo.MessageApplicationCommand {
+			problems = append(problems, checkMenuItem(c)...)
+			continue
+		}
+		problems = append(problems, checkDefinition("/"+c.Name, c.Name, c.Description, c.Options)...)
+		if c.Autocomplete == nil && autocompletes(c.Options) {
+			problems = append(problems, fmt.Sprintf("/%v has autocomplete options but nothing to complete them", c.Name))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("bad command definitions:\n  %v", strings.Join(problems, "\n  "))
+}
+
+func checkDefinition(path, name, description string, options []*discordgo.ApplicationCommandOption) []string {
+	var problems []string
+	if !commandName.MatchString(name) {
+		problems = append(problems, fmt.Sprintf("%v: name should be 1-32 lowercase letters, numbers, - or _", path))
+	}
+	if len(description) == 0 || len(description) > 100 {
+		problems = append(problems, fmt.Sprintf("%v: description should be 1-100 characters", path))
+	}
+	if len(options) > 25 {
+		problems = append(problems, fmt.Sprintf("%v: can't have more than 25 options", path))
+	}
+	seen := map[string]bool{}
+	optional := false
+	for _, o := range options {
+		optionPath := path + " " + o.Name
+		if seen[o.Name] {
+			problems = append(problems, fmt.Sprintf("%v: option is declared twice", optionPath))
+		}
+		seen[o.Name] = true
+		if o.Required && optional {
+			problems = append(problems, fmt.Sprintf("%v: required options have to come before optional ones", optionPath))
+		}
+		optional = optional || !o.Required
+		if len(o.Choices) > 25 {
+			problems = append(problems, fmt.Sprintf("%v: can't have more than 25 choices", optionPath))
+		}
+		problems = append(problems, checkDefinition(optionPath, o.Name, o.Description, o.Options)...)
+	}
+	return problems
+}
+
+// checkMenuItem checks a context menu command, which can have a name with capitals and spaces
+// but nothing else
+func checkMenuItem(c *command) []string {
+	var problems []string
+	if n := utf8.RuneCountInString(c.Name); n == 0 || n > 32 {
+		problems = append(problems, fmt.Sprintf("%q: menu names should be 1-32 characters", c.Name))
+	}
+	if c.Description != "" || len(c.Options) > 0 {
+		problems = append(problems, fmt.Sprintf("%q: menu items can't have a description or options", c.Name))
+	}
+	return problems
+}
+
+func autocompletes(options []*discordgo.ApplicationCommandOption) bool {
+	for _, o := range options {
+		if o.Autocomplete || autocompletes(o.Options) {
+			return true
+		}
+	}
+	return false
+}
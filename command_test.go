@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestCommandDefinitions(t *testing.T) {
+	if err := checkCommands(commands); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckCommandsCatchesMistakes(t *testing.T) {
+	bad := []*command{
+		{
+			Name:        "Shouty",
+			Description: "Has a name Discord won't take",
+			Handler:     func(ctx context.Context, s botSession, i *discordgo.InteractionCreate, opts commandOptions) {},
+		},
+		{
+			Name:        "backwards",
+			Description: "Has a required option after an optional one",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionInteger, Name: "day", Description: "Day"},
+				{Type: discordgo.ApplicationCommandOptionString, Name: "song", Description: "Song", Required: true},
+			},
+		},
+		{
+			Type:        discordgo.MessageApplicationCommand,
+			Name:        "Do a thing",
+			Description: "Menu items can't have these",
+			Handler:     func(ctx context.Context, s botSession, i *discordgo.InteractionCreate, opts commandOptions) {},
+		},
+	}
+	err := checkCommands(bad)
+	if err == nil {
+		t.Fatal("Bad definitions passed")
+	}
+	for _, want := range []string{"/Shouty: name", "/backwards song: required", "/backwards has no handler", `"Do a thing": menu items`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%q doesn't mention %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// defaultConfigPath is read if it exists and no other path is given
+const defaultConfigPath = "kazooiebot.yaml"
+
+type config struct {
+	Discord struct {
+		Token string `yaml:"token"`
+	} `yaml:"discord"`
+
+	// Guilds are the servers to register commands in, keyed by ID, along with anything they
+	// do differently from the defaults below. With no guilds, commands are registered globally
+	Guilds map[string]guildConfig `yaml:"guilds"`
+
+	Storage struct {
+		// Backend is either firestore or bolt
+		Backend    string `yaml:"backend"`
+		GCPProject string `yaml:"gcp_project"`
+		Path       string `yaml:"path"`
+	} `yaml:"storage"`
+
+	YouTube struct {
+		ClientSecret string `yaml:"client_secret"`
+		// TokenKey encrypts the saved YouTube login, so a leaked database doesn't leak the account
+		TokenKey string `yaml:"token_key"`
+	} `yaml:"youtube"`
+
+	// Owners are the Discord users who get suggestions and can do anything in any guild
+	Owners []string `yaml:"owners"`
+
+	Branding struct {
+		Community  string `yaml:"community"`
+		Maintainer string `yaml:"maintainer"`
+		SourceURL  string `yaml:"source_url"`
+	} `yaml:"branding"`
+
+	Gifs struct {
+		Hup     string `yaml:"hup"`
+		RareHup string `yaml:"rare_hup"`
+		Bogart  string `yaml:"bogart"`
+	} `yaml:"gifs"`
+
+	Music struct {
+		PlaylistTitlePrefix string `yaml:"playlist_title_prefix"`
+		// GraceDays is how long into a month the previous month still counts as current
+		GraceDays int `yaml:"grace_days"`
+		// PromptTime is the HH:MM each day's prompt is announced at, in PromptTimezone, for
+		// guilds with a prompt channel
+		PromptTime     string `yaml:"prompt_time"`
+		PromptTimezone string `yaml:"prompt_timezone"`
+	} `yaml:"music"`
+
+	Calendar struct {
+		// Listen is the address to serve calendar feeds on, like localhost:8080. Leaving it
+		// empty turns feeds off, though /calendar can still send a file
+		Listen string `yaml:"listen"`
+		// BaseURL is where the feeds are reached from, if not straight at Listen over http
+		BaseURL string `yaml:"base_url"`
+	} `yaml:"calendar"`
+}
+
+// calendarBaseURL is where calendar feed links start, without a trailing slash
+func (c *config) calendarBaseURL() string {
+	if c.Calendar.BaseURL != "" {
+		return strings.TrimSuffix(c.Calendar.BaseURL, "/")
+	}
+	return "http://" + c.Calendar.Listen
+}
+
+// guildConfig is the per-guild overrides; anything left empty uses the bot-wide setting
+type guildConfig struct {
+	Community           string `yaml:"community"`
+	PlaylistTitlePrefix string `yaml:"playlist_title_prefix"`
+	GraceDays           *int   `yaml:"grace_days"`
+	// AdminRoles can do anything in the guild, including handing out permissions
+	AdminRoles []string `yaml:"admin_roles"`
+	// AdminChannel is told whenever something goes wrong with a command
+	AdminChannel string `yaml:"admin_channel"`
+	// PromptChannel gets each day's music prompt, with a thread for the day's picks
+	PromptChannel  string `yaml:"prompt_channel"`
+	PromptTime     string `yaml:"prompt_time"`
+	PromptTimezone string `yaml:"prompt_timezone"`
+}
+
+// guildSettings is what a guild ends up with once its overrides are applied
+type guildSettings struct {
+	Community           string
+	PlaylistTitlePrefix string
+	GraceDays           int
+	PromptChannel       string
+	PromptTime          string
+	PromptTimezone      string
+}
+
+var conf = defaultConfig()
+
+func defaultConfig() *config {
+	c := &config{}
+	c.Storage.Backend = "firestore"
+	c.Storage.Path = "kazooiebot.db"
+	c.YouTube.ClientSecret = "client_secret.json"
+	c.Branding.SourceURL = "https://github.com/mfcrocker/kazooiebot"
+	c.Gifs.Hup = "https://tenor.com/view/kitten-cat-jump-running-cute-gif-21817165"
+	c.Gifs.RareHup = "https://storage.googleapis.com/musicmonth/hUP.gif"
+	c.Gifs.Bogart = "https://cdn.discordapp.com/emojis/721104351220727859.png?v=1"
+	c.Music.GraceDays = 2
+	c.Music.PromptTime = "09:00"
+	c.Music.PromptTimezone = "UTC"
+	return c
+}
+
+// loadConfig reads the config file at path, then applies any KAZOOIEBOT_* environment
+// variables over the top. An empty path means the default file, which is allowed to be missing
+func loadConfig(path string) (*config, error) {
+	c := defaultConfig()
+	required := path != ""
+	if path == "" {
+		path = defaultConfigPath
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && (required || !os.IsNotExist(err)) {
+		return nil, fmt.Errorf("couldn't read config file: %v", err)
+	}
+	if err == nil {
+		if err := yaml.UnmarshalStrict(data, c); err != nil {
+			return nil, fmt.Errorf("couldn't parse %v: %v", path, err)
+		}
+	}
+	if err := c.applyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *config) applyEnv(lookup func(string) (string, bool)) error {
+	fields := map[string]*string{
+		"KAZOOIEBOT_DISCORD_TOKEN":         &c.Discord.Token,
+		"KAZOOIEBOT_STORAGE_BACKEND":       &c.Storage.Backend,
+		"KAZOOIEBOT_STORAGE_GCP_PROJECT":   &c.Storage.GCPProject,
+		"KAZOOIEBOT_STORAGE_PATH":          &c.Storage.Path,
+		"KAZOOIEBOT_YOUTUBE_CLIENT_SECRET": &c.YouTube.ClientSecret,
+		"KAZOOIEBOT_YOUTUBE_TOKEN_KEY":     &c.YouTube.TokenKey,
+		"KAZOOIEBOT_BRANDING_COMMUNITY":    &c.Branding.Community,
+		"KAZOOIEBOT_BRANDING_MAINTAINER":   &c.Branding.Maintainer,
+		"KAZOOIEBOT_BRANDING_SOURCE_URL":   &c.Branding.SourceURL,
+		"KAZOOIEBOT_GIFS_HUP":              &c.Gifs.Hup,
+		"KAZOOIEBOT_GIFS_RARE_HUP":         &c.Gifs.RareHup,
+		"KAZOOIEBOT_GIFS_BOGART":           &c.Gifs.Bogart,
+		"KAZOOIEBOT_MUSIC_PLAYLIST_PREFIX": &c.Music.PlaylistTitlePrefix,
+		"KAZOOIEBOT_MUSIC_PROMPT_TIME":     &c.Music.PromptTime,
+		"KAZOOIEBOT_MUSIC_PROMPT_TIMEZONE": &c.Music.PromptTimezone,
+		"KAZOOIEBOT_CALENDAR_LISTEN":       &c.Calendar.Listen,
+		"KAZOOIEBOT_CALENDAR_BASE_URL":     &c.Calendar.BaseURL,
+	}
+	for name, field := range fields {
+		if v, ok := lookup(name); ok {
+			*field = v
+		}
+	}
+	if v, ok := lookup("KAZOOIEBOT_OWNERS"); ok {
+		c.Owners = nil
+		for _, owner := range strings.Split(v, ",") {
+			if owner = strings.TrimSpace(owner); owner != "" {
+				c.Owners = append(c.Owners, owner)
+			}
+		}
+	}
+	// Guilds from the environment just get the defaults
+	if v, ok := lookup("KAZOOIEBOT_GUILDS"); ok {
+		for _, guildID := range strings.Split(v, ",") {
+			guildID = strings.TrimSpace(guildID)
+			if _, exists := c.Guilds[guildID]; guildID != "" && !exists {
+				if c.Guilds == nil {
+					c.Guilds = map[string]guildConfig{}
+				}
+				c.Guilds[guildID] = guildConfig{}
+			}
+		}
+	}
+	if v, ok := lookup("KAZOOIEBOT_MUSIC_GRACE_DAYS"); ok {
+		days, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("KAZOOIEBOT_MUSIC_GRACE_DAYS should be a number of days, not %q", v)
+		}
+		c.Music.GraceDays = days
+	}
+	return nil
+}
+
+var snowflake = regexp.MustCompile(`^\d+$`)
+
+// timeOfDay reads a setting like 09:00 or 9pm, the same way reminders read times
+func timeOfDay(s string) (hour, minute int, err error) {
+	word := strings.ToLower(strings.TrimSpace(s))
+	if word != "noon" && word != "midday" && word != "midnight" && !clockTime.MatchString(word) {
+		return 0, 0, fmt.Errorf("%q isn't a time like 09:00", s)
+	}
+	return parseClock(word)
+}
+
+// validate checks everything at once so a bad config can be fixed in one go
+func (c *config) validate() error {
+	var problems []string
+	if c.Discord.Token == "" {
+		problems = append(problems, "discord.token is required")
+	}
+	for guildID, g := range c.Guilds {
+		if !snowflake.MatchString(guildID) {
+			problems = append(problems, fmt.Sprintf("guild %q isn't a Discord ID", guildID))
+		}
+		if g.GraceDays != nil && (*g.GraceDays < 0 || *g.GraceDays > 27) {
+			problems = append(problems, fmt.Sprintf("guilds.%v.grace_days should be between 0 and 27", guildID))
+		}
+		if g.AdminChannel != "" && !snowflake.MatchString(g.AdminChannel) {
+			problems = append(problems, fmt.Sprintf("guilds.%v.admin_channel %q isn't a Discord ID", guildID, g.AdminChannel))
+		}
+		if g.PromptChannel != "" && !snowflake.MatchString(g.PromptChannel) {
+			problems = append(problems, fmt.Sprintf("guilds.%v.prompt_channel %q isn't a Discord ID", guildID, g.PromptChannel))
+		}
+		if g.PromptTime != "" {
+			if _, _, err := timeOfDay(g.PromptTime); err != nil {
+				problems = append(problems, fmt.Sprintf("guilds.%v.prompt_time %v", guildID, err))
+			}
+		}
+		if g.PromptTimezone != "" {
+			if _, err := findTimezone(g.PromptTimezone); err != nil {
+				problems = append(problems, fmt.Sprintf("guilds.%v.prompt_timezone %q isn't a time zone", guildID, g.PromptTimezone))
+			}
+		}
+		for _, roleID := range g.AdminRoles {
+			if !snowflake.MatchString(roleID) {
+				problems = append(problems, fmt.Sprintf("guilds.%v.admin_roles: %q isn't a Discord ID", guildID, roleID))
+			}
+		}
+	}
+	switch c.Storage.Backend {
+	case "firestore":
+		if c.Storage.GCPProject == "" {
+			problems = append(problems, "storage.gcp_project is required for firestore storage")
+		}
+	case "bolt":
+		if c.Storage.Path == "" {
+			problems = append(problems, "storage.path is required for bolt storage")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("storage.backend %q should be firestore or bolt", c.Storage.Backend))
+	}
+	for _, owner := range c.Owners {
+		if !snowflake.MatchString(owner) {
+			problems = append(problems, fmt.Sprintf("owners: %q isn't a Discord ID", owner))
+		}
+	}
+	if c.Branding.Community == "" {
+		problems = append(problems, "branding.community is required")
+	}
+	if c.Branding.Maintainer == "" {
+		problems = append(problems, "branding.maintainer is required")
+	}
+	urls := map[string]string{
+		"branding.source_url": c.Branding.SourceURL,
+		"gifs.hup":            c.Gifs.Hup,
+		"gifs.rare_hup":       c.Gifs.RareHup,
+		"gifs.bogart":         c.Gifs.Bogart,
+	}
+	if c.Calendar.BaseURL != "" {
+		urls["calendar.base_url"] = c.Calendar.BaseURL
+	}
+	for name, raw := range urls {
+		if u, err := url.Parse(raw); err != nil || u.Scheme == "" || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("%v %q isn't a full URL", name, raw))
+		}
+	}
+	if c.Music.GraceDays < 0 || c.Music.GraceDays > 27 {
+		problems = append(problems, "music.grace_days should be between 0 and 27")
+	}
+	if _, _, err := timeOfDay(c.Music.PromptTime); err != nil {
+		problems = append(problems, fmt.Sprintf("music.prompt_time %v", err))
+	}
+	if _, err := findTimezone(c.Music.PromptTimezone); err != nil {
+		problems = append(problems, fmt.Sprintf("music.prompt_timezone %q isn't a time zone", c.Music.PromptTimezone))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	// Map iteration above means the order isn't stable, so sort for readable output
+	sort.Strings(problems)
+	return errors.New("invalid config:\n  " + strings.Join(problems, "\n  "))
+}
+
+// guildIDs gets the guilds to register commands in, in a stable order. A single empty ID
+// means registering globally
+func (c *config) guildIDs() []string {
+	if len(c.Guilds) == 0 {
+		return []string{""}
+	}
+	ids := make([]string, 0, len(c.Guilds))
+	for guildID := range c.Guilds {
+		ids = append(ids, guildID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// guild gets the settings for a guild, falling back to the bot-wide ones
+func (c *config) guild(guildID string) guildSettings {
+	g := c.Guilds[guildID]
+	settings := guildSettings{
+		Community:           c.Branding.Community,
+		PlaylistTitlePrefix: c.Music.PlaylistTitlePrefix,
+		GraceDays:           c.Music.GraceDays,
+		PromptChannel:       g.PromptChannel,
+		PromptTime:          c.Music.PromptTime,
+		PromptTimezone:      c.Music.PromptTimezone,
+	}
+	if g.Community != "" {
+		settings.Community = g.Community
+		// The bot-wide prefix would name the wrong community
+		settings.PlaylistTitlePrefix = ""
+	}
+	if g.PlaylistTitlePrefix != "" {
+		settings.PlaylistTitlePrefix = g.PlaylistTitlePrefix
+	}
+	if settings.PlaylistTitlePrefix == "" {
+		settings.PlaylistTitlePrefix = settings.Community + " Music Month: "
+	}
+	if g.GraceDays != nil {
+		settings.GraceDays = *g.GraceDays
+	}
+	if g.PromptTime != "" {
+		settings.PromptTime = g.PromptTime
+	}
+	if g.PromptTimezone != "" {
+		settings.PromptTimezone = g.PromptTimezone
+	}
+	return settings
+}
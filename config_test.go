@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "kazooiebot.yaml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `
+discord:
+  token: abc
+guilds:
+  "123": {}
+  "456":
+    community: Slowfriends
+    grace_days: 0
+    prompt_channel: "789"
+    prompt_timezone: Europe/London
+storage:
+  backend: bolt
+  path: /tmp/kazooie.db
+owners: ["456"]
+branding:
+  community: Speedfriends
+  maintainer: mfcrocker
+music:
+  grace_days: 3
+`)
+	os.Setenv("KAZOOIEBOT_DISCORD_TOKEN", "from-env")
+	defer os.Unsetenv("KAZOOIEBOT_DISCORD_TOKEN")
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("Couldn't load config: %v", err)
+	}
+	if c.Discord.Token != "from-env" {
+		t.Errorf("Environment didn't override the token, got %q", c.Discord.Token)
+	}
+	if c.Storage.Backend != "bolt" || c.Storage.Path != "/tmp/kazooie.db" {
+		t.Errorf("Storage not read from file: %+v", c.Storage)
+	}
+	if c.Music.GraceDays != 3 {
+		t.Errorf("Got %d grace days, want 3", c.Music.GraceDays)
+	}
+	if got := c.guild("123"); got.PlaylistTitlePrefix != "Speedfriends Music Month: " || got.GraceDays != 3 {
+		t.Errorf("Guild without overrides didn't get the defaults: %+v", got)
+	}
+	if got := c.guild("456"); got.Community != "Slowfriends" || got.PlaylistTitlePrefix != "Slowfriends Music Month: " || got.GraceDays != 0 {
+		t.Errorf("Guild overrides weren't applied: %+v", got)
+	}
+	if got := c.guild("456"); got.PromptChannel != "789" || got.PromptTime != "09:00" || got.PromptTimezone != "Europe/London" {
+		t.Errorf("Prompt settings weren't applied: %+v", got)
+	}
+	if got := c.guildIDs(); len(got) != 2 || got[0] != "123" || got[1] != "456" {
+		t.Errorf("Got guilds %q, want 123 and 456", got)
+	}
+	if c.Gifs.Hup == "" {
+		t.Error("Gif defaults were lost")
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     []string
+	}{
+		{
+			name:     "missing everything",
+			contents: "{}",
+			want:     []string{"discord.token is required", "storage.gcp_project is required", "branding.community is required"},
+		},
+		{
+			name: "bad values",
+			contents: `
+discord: {token: abc}
+guilds: {speedfriends: {}, "123": {prompt_channel: general, prompt_time: "25:00"}}
+storage: {backend: postgres}
+owners: [mfcrocker]
+branding: {community: a, maintainer: b}
+gifs: {hup: not-a-url}
+music: {grace_days: -1, prompt_timezone: Mars/Olympus}
+`,
+			want: []string{"guilds.123.prompt_channel", "guilds.123.prompt_time", "music.prompt_timezone", "guild \"speedfriends\"", "storage.backend \"postgres\"", "owners: \"mfcrocker\"", "gifs.hup", "music.grace_days"},
+		},
+		{
+			name:     "unknown key",
+			contents: "discrod: {token: abc}",
+			want:     []string{"field discrod not found"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := loadConfig(writeConfig(t, test.contents))
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			for _, want := range test.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Error %q doesn't mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigMissingFile(t *testing.T) {
+	if _, err := loadConfig(filepath.Join(t.TempDir(), "nope.yaml")); err == nil {
+		t.Error("Expected an error for a config file that was asked for but doesn't exist")
+	}
+}
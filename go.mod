@@ -7,6 +7,9 @@ require (
 	firebase.google.com/go v3.13.0+incompatible
 	github.com/bwmarrin/discordgo v0.28.1
 	github.com/robfig/cron/v3 v3.0.1
+	go.etcd.io/bbolt v1.3.5
 	golang.org/x/oauth2 v0.0.0-20210514164344-f6687ab2804c
 	google.golang.org/api v0.47.0
+	google.golang.org/grpc v1.37.1
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// interactionFixture is a synthetic interaction from testdata/interactions, along with
+// everything the bot should do in response to it
+type interactionFixture struct {
+	Interaction json.RawMessage    `json:"interaction"`
+	Responses   []expectedResponse `json:"responses"`
+	// Edits are what a deferred response was edited to, in order
+	Edits        []string            `json:"edits"`
+	Messages     map[string][]string `json:"messages"`
+	RolesAdded   []string            `json:"roles_added"`
+	RolesRemoved []string            `json:"roles_removed"`
+	// Grants are stored before the interaction is handled
+	Grants []grant `json:"grants"`
+}
+
+type expectedResponse struct {
+	Type    discordgo.InteractionResponseType `json:"type"`
+	Content string                            `json:"content"`
+	// OneOf is for handlers that pick their response at random
+	OneOf []string               `json:"one_of"`
+	Flags discordgo.MessageFlags `json:"flags"`
+}
+
+// useTestBot points the bot at an empty bolt store and a test config for the length of a test
+func useTestBot(t *testing.T) {
+	t.Helper()
+	conf = defaultConfig()
+	conf.Owners = []string{"999"}
+	conf.Branding.Community = "Test Friends"
+	conf.Branding.Maintainer = "tester"
+	conf.Music.PlaylistTitlePrefix = "Test Friends Music Month: "
+	testDB, err := newBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Couldn't open test store: %v", err)
+	}
+	db = testDB
+	reminderTimers = newReminderScheduler()
+	t.Cleanup(func() {
+		db = nil
+		conf = defaultConfig()
+		testDB.Close()
+	})
+}
+
+func loadInteraction(t *testing.T, raw json.RawMessage) *discordgo.InteractionCreate {
+	t.Helper()
+	var i discordgo.Interaction
+	if err := json.Unmarshal(raw, &i); err != nil {
+		t.Fatalf("Couldn't decode interaction: %v", err)
+	}
+	return &discordgo.InteractionCreate{Interaction: &i}
+}
+
+func TestInteractionFixtures(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "interactions", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("No interaction fixtures found")
+	}
+	for _, path := range paths {
+		path := path
+		t.Run(strings.TrimSuffix(filepath.Base(path), ".json"), func(t *testing.T) {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fixture interactionFixture
+			if err := json.Unmarshal(data, &fixture); err != nil {
+				t.Fatalf("Couldn't decode fixture: %v", err)
+			}
+
+			useTestBot(t)
+			for _, g := range fixture.Grants {
+				if err := db.AddGrant(context.Background(), g); err != nil {
+					t.Fatal(err)
+				}
+			}
+			fake := newFakeSession()
+			handleInteraction(context.Background(), fake, loadInteraction(t, fixture.Interaction))
+
+			if len(fake.responses) != len(fixture.Responses) {
+				t.Fatalf("Got %d responses, want %d: %+v", len(fake.responses), len(fixture.Responses), fake.responses)
+			}
+			for n, want := range fixture.Responses {
+				got := fake.responses[n]
+				if got.Type != want.Type {
+					t.Errorf("Response %d has type %v, want %v", n, got.Type, want.Type)
+				}
+				if got.Data == nil {
+					// Deferred responses don't need any
+					got.Data = &discordgo.InteractionResponseData{}
+				}
+				if got.Data.Flags != want.Flags {
+					t.Errorf("Response %d has flags %v, want %v", n, got.Data.Flags, want.Flags)
+				}
+				if len(want.OneOf) > 0 {
+					found := false
+					for _, content := range want.OneOf {
+						found = found || got.Data.Content == content
+					}
+					if !found {
+						t.Errorf("Response %d is %q, want one of %q", n, got.Data.Content, want.OneOf)
+					}
+				} else if got.Data.Content != want.Content {
+					t.Errorf("Response %d is %q, want %q", n, got.Data.Content, want.Content)
+				}
+			}
+
+			if len(fake.responseEdits) > 0 || len(fixture.Edits) > 0 {
+				if !reflect.DeepEqual(fake.responseEdits, fixture.Edits) {
+					t.Errorf("Edited response to %q, want %q", fake.responseEdits, fixture.Edits)
+				}
+			}
+			if len(fake.messages) > 0 || len(fixture.Messages) > 0 {
+				if !reflect.DeepEqual(fake.messages, fixture.Messages) {
+					t.Errorf("Sent messages %q, want %q", fake.messages, fixture.Messages)
+				}
+			}
+			if len(fake.rolesAdded) > 0 || len(fixture.RolesAdded) > 0 {
+				if !reflect.DeepEqual(fake.rolesAdded, fixture.RolesAdded) {
+					t.Errorf("Added roles %q, want %q", fake.rolesAdded, fixture.RolesAdded)
+				}
+			}
+			if len(fake.rolesRemoved) > 0 || len(fixture.RolesRemoved) > 0 {
+				if !reflect.DeepEqual(fake.rolesRemoved, fixture.RolesRemoved) {
+					t.Errorf("Removed roles %q, want %q", fake.rolesRemoved, fixture.RolesRemoved)
+				}
+			}
+		})
+	}
+}
+
+func TestReminderDelivered(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	_, err := db.AddReminder(ctx, reminder{
+		UserID:   "100",
+		Reminder: "feed the bird",
+		Date:     time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := newFakeSession()
+	checkReminders(ctx, fake)
+
+	want := []string{"Hi there! You asked me to remind you about feed the bird - this is that reminder!"}
+	if got := fake.messages["dm-100"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Sent %q, want %q", got, want)
+	}
+	if len(fake.sent) != 1 || len(fake.sent[0].Components) != 1 {
+		t.Errorf("Sent %+v, want snooze buttons", fake.sent)
+	}
+
+	// It's kept so it can be snoozed, but only for a while
+	left, err := db.DueReminders(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 1 || !left[0].Delivered {
+		t.Fatalf("Left %+v, want it kept as delivered", left)
+	}
+	checkReminders(ctx, fake)
+	if len(fake.messages["dm-100"]) != 1 {
+		t.Errorf("Delivered it again: %q", fake.messages["dm-100"])
+	}
+	left[0].Date = time.Now().Add(-snoozeWindow - time.Minute)
+	db.UpdateReminder(ctx, left[0])
+	checkReminders(ctx, fake)
+	if left, _ := db.DueReminders(ctx, time.Now()); len(left) != 0 {
+		t.Errorf("Delivered reminder wasn't cleared up: %+v", left)
+	}
+}
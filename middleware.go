@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// interactionHandler handles a whole interaction of any type
+type interactionHandler func(ctx context.Context, s botSession, i *discordgo.InteractionCreate)
+
+// middleware wraps an interactionHandler to do something around every interaction
+type middleware func(next interactionHandler) interactionHandler
+
+// middlewares run around every interaction, outermost first
+var middlewares = []middleware{withDeadline, trackResponses, recoverPanics}
+
+// handleInteraction runs an interaction through the middlewares and on to whatever handles it
+func handleInteraction(ctx context.Context, s botSession, i *discordgo.InteractionCreate) {
+	h := routeInteraction
+	for n := len(middlewares) - 1; n >= 0; n-- {
+		h = middlewares[n](h)
+	}
+	h(ctx, s, i)
+}
+
+// describe says what an interaction was and who it came from, for logs and reports
+func describe(i *discordgo.InteractionCreate) string {
+	var what string
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand, discordgo.InteractionApplicationCommandAutocomplete:
+		data := i.ApplicationCommandData()
+		what = "/" + data.Name
+		if data.CommandType == discordgo.MessageApplicationCommand || data.CommandType == discordgo.UserApplicationCommand {
+			what = "menu item " + data.Name
+		}
+	case discordgo.InteractionMessageComponent:
+		what = "component " + i.MessageComponentData().CustomID
+	case discordgo.InteractionModalSubmit:
+		what = "modal " + i.ModalSubmitData().CustomID
+	default:
+		what = fmt.Sprintf("interaction type %v", i.Type)
+	}
+	if user := interactionUser(i); user != nil {
+		what += " by " + user.Username + " (" + user.ID + ")"
+	}
+	if i.GuildID != "" {
+		what += " in guild " + i.GuildID
+	}
+	return what
+}
+
+// interactionUser gets whoever caused an interaction, in a guild or a DM
+func interactionUser(i *discordgo.InteractionCreate) *discordgo.User {
+	if i.Member != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+// report logs an interaction going wrong and tells the guild's admins
+func report(s botSession, i *discordgo.InteractionCreate, err error) {
+	log.Printf("%v: %v", describe(i), err)
+	if t, ok := s.(*trackedSession); ok {
+		t.mu.Lock()
+		t.failed = true
+		t.mu.Unlock()
+		// Don't let a failed report get reported
+		s = t.botSession
+	}
+	notifyAdmins(s, i.GuildID, "Something went wrong with "+describe(i)+": "+err.Error())
+}
+
+// notifyAdmins posts to a guild's admin channel, if it has one, without pinging anyone
+func notifyAdmins(s botSession, guildID, message string) {
+	channelID := conf.Guilds[guildID].AdminChannel
+	if channelID == "" {
+		return
+	}
+	if len(message) > 2000 {
+		message = message[:1997] + "..."
+	}
+	_, err := s.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Content:         message,
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	if err != nil {
+		log.Printf("Couldn't report to admin channel %v: %v", channelID, err)
+	}
+}
+
+// trackedSession notes whether an interaction was answered and reports anything Discord refuses
+type trackedSession struct {
+	botSession
+	i         *discordgo.InteractionCreate
+	mu        sync.Mutex
+	responded bool
+	// deferred is set while the response is only an acknowledgement
+	deferred bool
+	edited   bool
+	// failed is set once something's been reported
+	failed bool
+}
+
+func (t *trackedSession) check(what string, err error) error {
+	if err != nil {
+		report(t, t.i, fmt.Errorf("%v: %v", what, err))
+	}
+	return err
+}
+
+func (t *trackedSession) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error {
+	err := t.check("responding", t.botSession.InteractionRespond(interaction, resp, options...))
+	if err == nil {
+		t.mu.Lock()
+		t.responded = true
+		t.deferred = resp.Type == discordgo.InteractionResponseDeferredChannelMessageWithSource || resp.Type == discordgo.InteractionResponseDeferredMessageUpdate
+		t.mu.Unlock()
+	}
+	return err
+}
+
+func (t *trackedSession) InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	msg, err := t.botSession.InteractionResponseEdit(interaction, newresp, options...)
+	if t.check("editing the response", err) == nil {
+		t.mu.Lock()
+		t.edited = true
+		t.mu.Unlock()
+	}
+	return msg, err
+}
+
+func (t *trackedSession) FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	msg, err := t.botSession.FollowupMessageCreate(interaction, wait, data, options...)
+	return msg, t.check("sending a followup", err)
+}
+
+func (t *trackedSession) FollowupMessageEdit(interaction *discordgo.Interaction, messageID string, data *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	msg, err := t.botSession.FollowupMessageEdit(interaction, messageID, data, options...)
+	return msg, t.check("editing a followup", err)
+}
+
+func (t *trackedSession) UserChannelCreate(recipientID string, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	channel, err := t.botSession.UserChannelCreate(recipientID, options...)
+	return channel, t.check("opening a DM with "+recipientID, err)
+}
+
+func (t *trackedSession) ChannelMessageSend(channelID string, content string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	msg, err := t.botSession.ChannelMessageSend(channelID, content, options...)
+	return msg, t.check("sending a message to "+channelID, err)
+}
+
+func (t *trackedSession) ChannelMessages(channelID string, limit int, beforeID, afterID, aroundID string, options ...discordgo.RequestOption) ([]*discordgo.Message, error) {
+	msgs, err := t.botSession.ChannelMessages(channelID, limit, beforeID, afterID, aroundID, options...)
+	return msgs, t.check("reading messages in "+channelID, err)
+}
+
+func (t *trackedSession) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	msg, err := t.botSession.ChannelMessageSendComplex(channelID, data, options...)
+	return msg, t.check("sending a message to "+channelID, err)
+}
+
+func (t *trackedSession) MessageThreadStart(channelID, messageID string, name string, archiveDuration int, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	thread, err := t.botSession.MessageThreadStart(channelID, messageID, name, archiveDuration, options...)
+	return thread, t.check("starting a thread in "+channelID, err)
+}
+
+func (t *trackedSession) GuildMemberRoleAdd(guildID, userID, roleID string, options ...discordgo.RequestOption) error {
+	return t.check("adding role "+roleID+" to "+userID, t.botSession.GuildMemberRoleAdd(guildID, userID, roleID, options...))
+}
+
+func (t *trackedSession) GuildMemberRoleRemove(guildID, userID, roleID string, options ...discordgo.RequestOption) error {
+	return t.check("removing role "+roleID+" from "+userID, t.botSession.GuildMemberRoleRemove(guildID, userID, roleID, options...))
+}
+
+func (t *trackedSession) UserChannelPermissions(userID, channelID string, fetchOptions ...discordgo.RequestOption) (int64, error) {
+	perms, err := t.botSession.UserChannelPermissions(userID, channelID, fetchOptions...)
+	return perms, t.check("checking "+userID+"'s permissions in "+channelID, err)
+}
+
+// trackResponses makes sure every interaction gets a real answer
+func trackResponses(next interactionHandler) interactionHandler {
+	return func(ctx context.Context, s botSession, i *discordgo.InteractionCreate) {
+		t := &trackedSession{botSession: s, i: i}
+		next(ctx, t, i)
+		t.mu.Lock()
+		responded, deferred, edited, failed := t.responded, t.deferred, t.edited, t.failed
+		t.mu.Unlock()
+		if responded && (!deferred || edited) {
+			return
+		}
+
+		content := "Something went wrong at my end, sorry"
+		if ctx.Err() == context.DeadlineExceeded {
+			content = timeoutMessage
+			report(s, i, errors.New("timed out"))
+		} else if !failed {
+			report(s, i, errors.New("finished without answering"))
+		}
+		if deferred {
+			s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content})
+			return
+		}
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags:   64,
+				Content: content,
+			},
+		})
+	}
+}
+
+// defaultTimeout is how long a handler has if its command doesn't say
+const defaultTimeout = 3 * time.Second
+
+// withDeadline gives each interaction a context that runs out when its handler should give up
+func withDeadline(next interactionHandler) interactionHandler {
+	return func(ctx context.Context, s botSession, i *discordgo.InteractionCreate) {
+		timeout := defaultTimeout
+		if i.Type == discordgo.InteractionApplicationCommand {
+			if c := findCommand(i.ApplicationCommandData().Name); c != nil && c.Timeout != 0 {
+				timeout = c.Timeout
+			}
+		} else if t := customIDTimeout(i); t != 0 {
+			timeout = t
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		next(ctx, s, i)
+	}
+}
+
+// recoverPanics stops one broken handler from taking the whole bot down
+func recoverPanics(next interactionHandler) interactionHandler {
+	return func(ctx context.Context, s botSession, i *discordgo.InteractionCreate) {
+		defer func() {
+			if r := recover(); r != nil {
+				report(s, i, fmt.Errorf("panic: %v", r))
+				log.Printf("%s", debug.Stack())
+			}
+		}()
+		next(ctx, s, i)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func componentClick(prefix string) *discordgo.InteractionCreate {
+	id, _ := customID(prefix)
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		Type:    discordgo.InteractionMessageComponent,
+		GuildID: "1",
+		Member:  &discordgo.Member{User: &discordgo.User{ID: "100", Username: "banjo"}},
+		Data:    discordgo.MessageComponentInteractionData{CustomID: id},
+	}}
+}
+
+func TestPanicsAreRecoveredAndReported(t *testing.T) {
+	useTestBot(t)
+	conf.Guilds = map[string]guildConfig{"1": {AdminChannel: "77"}}
+	components["explode"] = customIDRoute{Handler: func(ctx context.Context, s botSession, i *discordgo.InteractionCreate, args []string) {
+		var m *month
+		_ = m.Days[0]
+	}}
+	defer delete(components, "explode")
+
+	fake := newFakeSession()
+	handleInteraction(context.Background(), fake, componentClick("explode"))
+
+	if len(fake.responses) != 1 || fake.responses[0].Data.Flags != 64 {
+		t.Fatalf("Got responses %+v, want one ephemeral apology", fake.responses)
+	}
+	reports := fake.messages["77"]
+	if len(reports) != 1 || !strings.Contains(reports[0], "component explode by banjo (100) in guild 1: panic:") {
+		t.Errorf("Reported %q", reports)
+	}
+}
+
+func TestMissingResponseIsReported(t *testing.T) {
+	useTestBot(t)
+	conf.Guilds = map[string]guildConfig{"1": {AdminChannel: "77"}}
+	components["quiet"] = customIDRoute{Handler: func(ctx context.Context, s botSession, i *discordgo.InteractionCreate, args []string) {}}
+	defer delete(components, "quiet")
+
+	fake := newFakeSession()
+	handleInteraction(context.Background(), fake, componentClick("quiet"))
+
+	if len(fake.responses) != 1 {
+		t.Fatalf("Got responses %+v, want one apology", fake.responses)
+	}
+	if reports := fake.messages["77"]; len(reports) != 1 || !strings.HasSuffix(reports[0], "finished without answering") {
+		t.Errorf("Reported %q", reports)
+	}
+}
+
+func TestRespondingHandlerIsLeftAlone(t *testing.T) {
+	useTestBot(t)
+	conf.Guilds = map[string]guildConfig{"1": {AdminChannel: "77"}}
+
+	fake := newFakeSession()
+	handleInteraction(context.Background(), fake, loadInteraction(t, []byte(`{"type": 2, "guild_id": "1", "member": {"user": {"id": "100"}}, "data": {"name": "birdass", "type": 1}}`)))
+
+	if len(fake.responses) != 1 || fake.responses[0].Data.Content != "just birdass" {
+		t.Errorf("Got responses %+v", fake.responses)
+	}
+	if len(fake.messages) != 0 {
+		t.Errorf("Reported %q", fake.messages)
+	}
+}
+
+func TestSlowDeferredCommandTimesOut(t *testing.T) {
+	useTestBot(t)
+	slow := &command{
+		Name:        "slow",
+		Description: "Never finishes in time",
+		Timeout:     10 * time.Millisecond,
+		Handler: func(ctx context.Context, s botSession, i *discordgo.InteractionCreate, opts commandOptions) {
+			deferResponse(s, i)
+			<-ctx.Done()
+		},
+	}
+	commands = append(commands, slow)
+	defer func() { commands = commands[:len(commands)-1] }()
+
+	fake := newFakeSession()
+	handleInteraction(context.Background(), fake, loadInteraction(t, []byte(`{"type": 2, "guild_id": "1", "member": {"user": {"id": "100"}}, "data": {"name": "slow", "type": 1}}`)))
+
+	if len(fake.responses) != 1 || fake.responses[0].Type != discordgo.InteractionResponseDeferredChannelMessageWithSource {
+		t.Fatalf("Got responses %+v, want just the deferral", fake.responses)
+	}
+	if !reflect.DeepEqual(fake.responseEdits, []string{timeoutMessage}) {
+		t.Errorf("Edited response to %q", fake.responseEdits)
+	}
+}
+
+func TestComponentsGetTheirOwnTimeout(t *testing.T) {
+	useTestBot(t)
+	var left time.Duration
+	components["patient"] = customIDRoute{Timeout: time.Minute, Handler: func(ctx context.Context, s botSession, i *discordgo.InteractionCreate, args []string) {
+		deadline, _ := ctx.Deadline()
+		left = time.Until(deadline)
+		respondQuietly(s, i, "done")
+	}}
+	defer delete(components, "patient")
+
+	handleInteraction(context.Background(), newFakeSession(), componentClick("patient"))
+	if left <= defaultTimeout {
+		t.Errorf("Handler had %v, want its own minute", left)
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// monthOption picks which month /musicadmin changes
+var monthOption = &discordgo.ApplicationCommandOption{
+	Type:        discordgo.ApplicationCommandOptionString,
+	Name:        "month",
+	Description: "The month to change, like Mar 2024 (the current one if left out)",
+}
+
+func dayOption(name, description string) *discordgo.ApplicationCommandOption {
+	return &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionInteger,
+		Name:        name,
+		Description: description,
+		Required:    true,
+		MinValue:    &minOne,
+		MaxValue:    31,
+	}
+}
+
+// musicAdminCommand lets organisers fix a music month in place rather than uploading it again
+var musicAdminCommand = &command{
+	Name:        "musicadmin",
+	Description: "Change a music month that's been set up - only for music organisers",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommandGroup,
+			Name:        "prompt",
+			Description: "Change a day's prompt",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "set",
+					Description: "Set or replace a day's prompt",
+					Options: []*discordgo.ApplicationCommandOption{
+						dayOption("day", "The day to set"),
+						{Type: discordgo.ApplicationCommandOptionString, Name: "prompt", Description: "The new prompt", Required: true},
+						monthOption,
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "swap",
+					Description: "Swap two days' prompts",
+					Options:     []*discordgo.ApplicationCommandOption{dayOption("day", "One day"), dayOption("other", "The other day"), monthOption},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "clear",
+					Description: "Take a day's prompt away",
+					Options:     []*discordgo.ApplicationCommandOption{dayOption("day", "The day to clear"), monthOption},
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommandGroup,
+			Name:        "month",
+			Description: "Change a whole month",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "delete",
+					Description: "Delete a month, leaving any picks for it",
+					Options:     []*discordgo.ApplicationCommandOption{monthOption},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "reschedule",
+					Description: "Move a month before anyone's picked songs for it",
+					Options: []*discordgo.ApplicationCommandOption{
+						{Type: discordgo.ApplicationCommandOptionString, Name: "to", Description: "The month to move it to, like Apr 2024", Required: true},
+						monthOption,
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "history",
+					Description: "See who's changed months and how",
+					Options:     []*discordgo.ApplicationCommandOption{monthOption},
+				},
+			},
+		},
+	},
+	Timeout: 10 * time.Second,
+	Handler: requires(capMusicOrganiser, musicAdminHandler),
+}
+
+// monthNameLayouts are the ways an organiser can name a month
+var monthNameLayouts = []string{"Jan 2006", "January 2006", "2006-01", "1/2006"}
+
+// parseMonthName reads a month like Mar 2024, returning midnight UTC on its first
+func parseMonthName(name string) (time.Time, error) {
+	name = strings.TrimSpace(name)
+	for _, layout := range monthNameLayouts {
+		if t, err := time.Parse(layout, name); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q isn't a month like Mar 2024", name)
+}
+
+// storedMonth gets the guild's music month starting in the named month, or errNotFound
+func storedMonth(ctx context.Context, guildID, name string) (*month, error) {
+	start, err := parseMonthName(name)
+	if err != nil {
+		return nil, err
+	}
+	// Months can start on the first in any time zone, which might still be the day before in UTC
+	m, err := db.NextMonth(ctx, guildID, start.AddDate(0, 0, -2))
+	if err != nil {
+		return nil, err
+	}
+	// Stored start times come back in UTC, so look at them where the month's run
+	loc, err := findTimezone(conf.guild(guildID).PromptTimezone)
+	if err != nil {
+		return nil, err
+	}
+	if m.StartTime.In(loc).Format("Jan 2006") != start.Format("Jan 2006") {
+		return nil, errNotFound
+	}
+	return m, nil
+}
+
+func musicAdminHandler(ctx context.Context, s botSession, i *discordgo.InteractionCreate, opts commandOptions) {
+	if db == nil {
+		// We're not connected to GCP, don't let them do this
+		respondQuietly(s, i, "I haven't been set up to allow music months, please moan at whoever set me up")
+		return
+	}
+	if opts.Subcommand() == "month history" {
+		monthHistory(ctx, s, i, opts)
+		return
+	}
+
+	var m *month
+	var err error
+	if opts.Has("month") {
+		if _, err := parseMonthName(opts.String("month")); err != nil {
+			respondQuietly(s, i, "I can't do that: "+err.Error())
+			return
+		}
+		m, err = storedMonth(ctx, i.GuildID, opts.String("month"))
+	} else {
+		m, err = activeMonth(ctx, i.GuildID, time.Now().In(userLocation(ctx, s, i)))
+	}
+	if err == errNotFound {
+		respondQuietly(s, i, "There's no music month set up for then")
+		return
+	}
+	if err != nil {
+		respondQuietly(s, i, "Something went wrong at my end, sorry")
+		report(s, i, fmt.Errorf("getting music month: %v", err))
+		return
+	}
+	monthName := m.StartTime.Format("Jan 2006")
+
+	var change string
+	switch opts.Subcommand() {
+	case "prompt set":
+		change, err = setPrompt(m, opts.Int("day"), strings.TrimSpace(opts.String("prompt")))
+	case "prompt swap":
+		change, err = swapPrompts(m, opts.Int("day"), opts.Int("other"))
+	case "prompt clear":
+		change, err = clearPrompt(m, opts.Int("day"))
+	case "month reschedule":
+		change, err = rescheduleMonth(ctx, m, opts.String("to"))
+	case "month delete":
+		if err := db.DeleteMonth(ctx, m.ID); err != nil {
+			respondQuietly(s, i, "Something went wrong at my end so I didn't delete the month")
+			report(s, i, fmt.Errorf("deleting music month: %v", err))
+			return
+		}
+		change = "deleted " + monthName + ", which had " + promptCount(len(m.Days))
+		recordMonthChange(ctx, s, i, monthName, change)
+		respondQuietly(s, i, "Okay, I've "+change)
+		return
+	}
+	if err != nil {
+		respondQuietly(s, i, "I can't do that: "+err.Error())
+		return
+	}
+	if err := db.UpdateMonth(ctx, *m); err != nil {
+		respondQuietly(s, i, "Something went wrong at my end so I didn't change the month")
+		report(s, i, fmt.Errorf("updating music month: %v", err))
+		return
+	}
+	recordMonthChange(ctx, s, i, monthName, change)
+	respondQuietly(s, i, "Okay, I've "+change)
+}
+
+// recordMonthChange adds to the audit trail of changes to months. The change says what was done
+// to which month, like "cleared day 3 of Mar 2024". It's already been done by then, so failing
+// to record it is only reported
+func recordMonthChange(ctx context.Context, s botSession, i *discordgo.InteractionCreate, monthName, change string) {
+	err := db.AddMonthChange(ctx, monthChange{
+		GuildID: i.GuildID,
+		Month:   monthName,
+		UserID:  interactionUser(i).ID,
+		Time:    time.Now(),
+		Change:  change,
+	})
+	if err != nil {
+		report(s, i, fmt.Errorf("recording change to %v: %v", monthName, err))
+	}
+}
+
+// promptCount says how many prompts there are, like "1 prompt"
+func promptCount(n int) string {
+	if n == 1 {
+		return "1 prompt"
+	}
+	return strconv.Itoa(n) + " prompts"
+}
+
+// promptIndex gets where a day is in a month's days, or -1
+func promptIndex(m *month, day int) int {
+	for n, d := range m.Days {
+		if d.Day == day {
+			return n
+		}
+	}
+	return -1
+}
+
+// checkDay makes sure a day is in a month
+func checkDay(m *month, day int) error {
+	if length := monthLength(m.StartTime); day < 1 || day > length {
+		return fmt.Errorf("%v only has %d days", m.StartTime.Format("January 2006"), length)
+	}
+	return nil
+}
+
+func setPrompt(m *month, dayOfMonth int, prompt string) (string, error) {
+	if err := checkDay(m, dayOfMonth); err != nil {
+		return "", err
+	}
+	if prompt == "" {
+		return "", errors.New("the prompt can't be empty")
+	}
+	n := promptIndex(m, dayOfMonth)
+	if n == -1 {
+		m.Days = append(m.Days, day{Day: dayOfMonth, Prompt: prompt})
+		sort.Slice(m.Days, func(a, b int) bool { return m.Days[a].Day < m.Days[b].Day })
+		return fmt.Sprintf("set day %d of %v to %q", dayOfMonth, m.StartTime.Format("Jan 2006"), prompt), nil
+	}
+	old := m.Days[n].Prompt
+	m.Days[n].Prompt = prompt
+	return fmt.Sprintf("changed day %d of %v from %q to %q", dayOfMonth, m.StartTime.Format("Jan 2006"), old, prompt), nil
+}
+
+func swapPrompts(m *month, one, other int) (string, error) {
+	for _, day := range []int{one, other} {
+		if err := checkDay(m, day); err != nil {
+			return "", err
+		}
+	}
+	if one == other {
+		return "", errors.New("those are the same day")
+	}
+	a, b := promptIndex(m, one), promptIndex(m, other)
+	if a == -1 && b == -1 {
+		return "", errors.New("neither day has a prompt")
+	}
+	// A day without a prompt just takes the other one's
+	if a != -1 {
+		m.Days[a].Day = other
+	}
+	if b != -1 {
+		m.Days[b].Day = one
+	}
+	sort.Slice(m.Days, func(a, b int) bool { return m.Days[a].Day < m.Days[b].Day })
+	return fmt.Sprintf("swapped days %d and %d of %v", one, other, m.StartTime.Format("Jan 2006")), nil
+}
+
+func clearPrompt(m *month, day int) (string, error) {
+	n := promptIndex(m, day)
+	if n == -1 {
+		return "", fmt.Errorf("day %d doesn't have a prompt", day)
+	}
+	old := m.Days[n].Prompt
+	m.Days = append(m.Days[:n], m.Days[n+1:]...)
+	return fmt.Sprintf("cleared day %d of %v, which was %q", day, m.StartTime.Format("Jan 2006"), old), nil
+}
+
+// rescheduleMonth moves a month to start on the first of another. Picks are saved against the
+// month's name, so it can't move once there are any
+func rescheduleMonth(ctx context.Context, m *month, to string) (string, error) {
+	target, err := parseMonthName(to)
+	if err != nil {
+		return "", err
+	}
+	from := m.StartTime.Format("Jan 2006")
+	// Keep the time of day and zone it was set up with
+	start := time.Date(target.Year(), target.Month(), 1, m.StartTime.Hour(), m.StartTime.Minute(), m.StartTime.Second(), 0, m.StartTime.Location())
+	if start.Format("Jan 2006") == from {
+		return "", fmt.Errorf("it's already in %v", m.StartTime.Format("January 2006"))
+	}
+	picks, err := db.Songs(ctx, m.GuildID, from, "", 0)
+	if err != nil {
+		return "", err
+	}
+	if len(picks) > 0 {
+		return "", errors.New("people have already picked songs for it")
+	}
+	if _, err := storedMonth(ctx, m.GuildID, start.Format("Jan 2006")); err == nil {
+		return "", fmt.Errorf("there's already a month in %v", start.Format("January 2006"))
+	} else if err != errNotFound {
+		return "", err
+	}
+	for _, d := range m.Days {
+		if d.Day > monthLength(start) {
+			return "", fmt.Errorf("day %d has a prompt but %v only has %d days", d.Day, start.Format("January 2006"), monthLength(start))
+		}
+	}
+	m.StartTime = start
+	return "moved " + from + " to " + start.Format("Jan 2006"), nil
+}
+
+// monthHistory lists the changes made to the guild's months, or to one month
+func monthHistory(ctx context.Context, s botSession, i *discordgo.InteractionCreate, opts commandOptions) {
+	changes, err := db.MonthChanges(ctx, i.GuildID)
+	if err != nil {
+		respondQuietly(s, i, "Something went wrong at my end, sorry")
+		report(s, i, fmt.Errorf("getting month changes: %v", err))
+		return
+	}
+	wanted := ""
+	if opts.Has("month") {
+		start, err := parseMonthName(opts.String("month"))
+		if err != nil {
+			respondQuietly(s, i, "I can't do that: "+err.Error())
+			return
+		}
+		wanted = start.Format("Jan 2006")
+	}
+	var lines []string
+	for _, c := range changes {
+		if wanted == "" || c.Month == wanted {
+			lines = append(lines, "<t:"+strconv.FormatInt(c.Time.Unix(), 10)+":f> <@"+c.UserID+"> "+c.Change)
+		}
+	}
+	if len(lines) == 0 {
+		respondQuietly(s, i, "Nobody's changed a music month since setting it up")
+		return
+	}
+	respondQuietly(s, i, limitLines("Changes to music months, newest first:", lines, func(int) string { return "...and older ones" }))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// musicAdmin runs a /musicadmin subcommand as user 100, returning what it said
+func musicAdmin(t *testing.T, group, sub string, options ...*discordgo.ApplicationCommandInteractionDataOption) string {
+	t.Helper()
+	fake := newFakeSession()
+	handleInteraction(context.Background(), fake, slashCommand("musicadmin", &discordgo.ApplicationCommandInteractionDataOption{
+		Name: group,
+		Type: discordgo.ApplicationCommandOptionSubCommandGroup,
+		Options: []*discordgo.ApplicationCommandInteractionDataOption{
+			{Name: sub, Type: discordgo.ApplicationCommandOptionSubCommand, Options: options},
+		},
+	}))
+	if len(fake.responses) != 1 {
+		t.Fatalf("Got responses %+v, want one", fake.responses)
+	}
+	return fake.responses[0].Data.Content
+}
+
+func TestMusicAdminPrompts(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	db.AddGrant(ctx, grant{GuildID: "1", Capability: capMusicOrganiser, UserID: "100"})
+	feb := time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)
+	db.AddMonth(ctx, month{GuildID: "1", StartTime: feb, Days: []day{{Day: 1, Prompt: "birds"}, {Day: 2, Prompt: "bears"}, {Day: 3, Prompt: "jiggies"}}})
+	inFeb := stringOption("month", "Feb 2024")
+
+	steps := []struct {
+		sub     string
+		options []*discordgo.ApplicationCommandInteractionDataOption
+		want    string
+	}{
+		{"set", []*discordgo.ApplicationCommandInteractionDataOption{intOption("day", 1), stringOption("prompt", "beaks"), inFeb}, `Okay, I've changed day 1 of Feb 2024 from "birds" to "beaks"`},
+		{"set", []*discordgo.ApplicationCommandInteractionDataOption{intOption("day", 4), stringOption("prompt", "notes"), inFeb}, `Okay, I've set day 4 of Feb 2024 to "notes"`},
+		{"set", []*discordgo.ApplicationCommandInteractionDataOption{intOption("day", 30), stringOption("prompt", "leap"), inFeb}, "I can't do that: February 2024 only has 29 days"},
+		{"swap", []*discordgo.ApplicationCommandInteractionDataOption{intOption("day", 2), intOption("other", 3), inFeb}, "Okay, I've swapped days 2 and 3 of Feb 2024"},
+		{"swap", []*discordgo.ApplicationCommandInteractionDataOption{intOption("day", 4), intOption("other", 9), inFeb}, "Okay, I've swapped days 4 and 9 of Feb 2024"},
+		{"clear", []*discordgo.ApplicationCommandInteractionDataOption{intOption("day", 1), inFeb}, `Okay, I've cleared day 1 of Feb 2024, which was "beaks"`},
+		{"clear", []*discordgo.ApplicationCommandInteractionDataOption{intOption("day", 1), inFeb}, "I can't do that: day 1 doesn't have a prompt"},
+		{"clear", []*discordgo.ApplicationCommandInteractionDataOption{intOption("day", 1), stringOption("month", "Jun 2024")}, "There's no music month set up for then"},
+	}
+	for _, step := range steps {
+		if got := musicAdmin(t, "prompt", step.sub, step.options...); got != step.want {
+			t.Errorf("Got %q, want %q", got, step.want)
+		}
+	}
+
+	m, err := db.NextMonth(ctx, "1", feb.AddDate(0, 0, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []day{{Day: 2, Prompt: "jiggies"}, {Day: 3, Prompt: "bears"}, {Day: 9, Prompt: "notes"}}
+	if len(m.Days) != len(want) {
+		t.Fatalf("Got days %+v, want %+v", m.Days, want)
+	}
+	for n := range want {
+		if m.Days[n] != want[n] {
+			t.Errorf("Got days %+v, want %+v", m.Days, want)
+			break
+		}
+	}
+
+	history := musicAdmin(t, "month", "history")
+	lines := strings.Split(history, "\n")
+	if len(lines) != 6 || !strings.HasSuffix(lines[1], `<@100> cleared day 1 of Feb 2024, which was "beaks"`) || !strings.Contains(lines[5], "changed day 1") {
+		t.Errorf("Got history %q, want every change newest first", history)
+	}
+}
+
+func TestStoredMonthAheadOfUTC(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	conf.Music.PromptTimezone = "Asia/Tokyo"
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	// As it comes back from Firestore, on the last day of February in UTC
+	start := time.Date(2024, time.March, 1, 0, 0, 0, 0, tokyo).UTC()
+	db.AddMonth(ctx, month{GuildID: "1", StartTime: start, Days: []day{{Day: 1, Prompt: "first"}}})
+
+	m, err := storedMonth(ctx, "1", "Mar 2024")
+	if err != nil {
+		t.Fatalf("Couldn't find March: %v", err)
+	}
+	if !m.StartTime.Equal(start) {
+		t.Errorf("Got the month starting %v, want %v", m.StartTime, start)
+	}
+	if _, err := storedMonth(ctx, "1", "Feb 2024"); err != errNotFound {
+		t.Errorf("Got %v for February, want errNotFound", err)
+	}
+}
+
+func TestMusicAdminMonths(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	db.AddGrant(ctx, grant{GuildID: "1", Capability: capMusicOrganiser, UserID: "100"})
+	db.AddMonth(ctx, month{GuildID: "1", StartTime: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC), Days: []day{{Day: 31, Prompt: "last"}}})
+	db.AddMonth(ctx, month{GuildID: "1", StartTime: time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC), Days: []day{{Day: 1, Prompt: "first"}}})
+	db.SaveSong(ctx, song{GuildID: "1", UserID: "100", Month: "Mar 2024", Day: 1, Song: "picked"})
+
+	steps := []struct {
+		sub     string
+		options []*discordgo.ApplicationCommandInteractionDataOption
+		want    string
+	}{
+		{"reschedule", []*discordgo.ApplicationCommandInteractionDataOption{stringOption("to", "Feb 2024"), stringOption("month", "Jan 2024")}, "I can't do that: day 31 has a prompt but February 2024 only has 29 days"},
+		{"reschedule", []*discordgo.ApplicationCommandInteractionDataOption{stringOption("to", "2024-03"), stringOption("month", "Jan 2024")}, "I can't do that: there's already a month in March 2024"},
+		{"reschedule", []*discordgo.ApplicationCommandInteractionDataOption{stringOption("to", "Apr 2024"), stringOption("month", "Mar 2024")}, "I can't do that: people have already picked songs for it"},
+		{"reschedule", []*discordgo.ApplicationCommandInteractionDataOption{stringOption("to", "someday"), stringOption("month", "Jan 2024")}, `I can't do that: "someday" isn't a month like Mar 2024`},
+		{"reschedule", []*discordgo.ApplicationCommandInteractionDataOption{stringOption("to", "May 2024"), stringOption("month", "January 2024")}, "Okay, I've moved Jan 2024 to May 2024"},
+		{"delete", []*discordgo.ApplicationCommandInteractionDataOption{stringOption("month", "Mar 2024")}, "Okay, I've deleted Mar 2024, which had 1 prompt"},
+	}
+	for _, step := range steps {
+		if got := musicAdmin(t, "month", step.sub, step.options...); got != step.want {
+			t.Errorf("Got %q, want %q", got, step.want)
+		}
+	}
+
+	m, err := db.NextMonth(ctx, "1", time.Date(2023, time.December, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil || m.StartTime.Format("Jan 2006") != "May 2024" || len(m.Days) != 1 {
+		t.Errorf("Got %+v, %v, want only the month moved to May", m, err)
+	}
+	if got := musicAdmin(t, "month", "history", stringOption("month", "Mar 2024")); !strings.HasSuffix(got, "<@100> deleted Mar 2024, which had 1 prompt") || strings.Contains(got, "May") {
+		t.Errorf("Got history %q, want just March's", got)
+	}
+}
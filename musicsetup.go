@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// monthFileLimit is the biggest music month file that'll be read
+const monthFileLimit = 64 << 10
+
+// monthFileClient fetches music month files, giving up before Discord does
+var monthFileClient = &http.Client{Timeout: 15 * time.Second}
+
+// monthFileName is what the checked month is attached to the preview as
+const monthFileName = "month.json"
+
+func musicSetupHandler(ctx context.Context, s botSession, i *discordgo.InteractionCreate, opts commandOptions) {
+	if db == nil {
+		// We're not connected to GCP, don't let them do this
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "I haven't been set up to allow music months, please moan at whoever set me up",
+			},
+		})
+		return
+	}
+	refuse := func(content string) {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags:   64,
+				Content: content,
+			},
+		})
+	}
+	var fileURL string
+	attachment := opts.Attachment("attachment")
+	switch {
+	case opts.Has("file") == (attachment != nil):
+		refuse("Give me a .json file, either as a link or attached, but not both")
+		return
+	case attachment != nil:
+		if !strings.HasSuffix(strings.ToLower(attachment.Filename), ".json") {
+			refuse("Give me a .json file")
+			return
+		}
+		if attachment.Size > monthFileLimit {
+			refuse("That file's too big for a music month")
+			return
+		}
+		fileURL = attachment.URL
+	default:
+		fileURL = opts.String("file")
+		if !strings.HasSuffix(fileURL, ".json") {
+			refuse("Give me a .json file")
+			return
+		}
+	}
+
+	// Fetching the file can easily take longer than Discord waits
+	deferResponse(s, i)
+	editResponse(ctx, s, i, "Fetching the month...")
+	data, err := fetchMonthFile(ctx, fileURL)
+	if err != nil {
+		editResponse(ctx, s, i, "Couldn't get the file: "+err.Error())
+		return
+	}
+	m, problems := parseMonth(data)
+	if len(problems) > 0 {
+		editResponse(ctx, s, i, problemList("That month has problems, so I haven't saved it:", problems))
+		return
+	}
+
+	// The checked month goes with the preview, so saving doesn't fetch the file again
+	checked, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		editResponse(ctx, s, i, "Something went wrong at my end so I didn't save the month")
+		report(s, i, fmt.Errorf("encoding music month: %v", err))
+		return
+	}
+	content := monthPreview(m)
+	// Neither button carries args, so neither ID can be too long
+	save, _ := customID("musicsetup-save")
+	cancel, _ := customID("musicsetup-cancel")
+	s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Content: &content,
+		Files:   []*discordgo.File{{Name: monthFileName, ContentType: "application/json", Reader: bytes.NewReader(checked)}},
+		Components: &[]discordgo.MessageComponent{
+			discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+				discordgo.Button{Label: "Save", Style: discordgo.SuccessButton, CustomID: save},
+				discordgo.Button{Label: "Cancel", Style: discordgo.SecondaryButton, CustomID: cancel},
+			}},
+		},
+	})
+}
+
+// fetchMonthFile downloads a music month file, refusing anything too big to be one
+func fetchMonthFile(ctx context.Context, fileURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, errors.New("that isn't a URL I can fetch")
+	}
+	resp, err := monthFileClient.Do(req)
+	if err != nil {
+		return nil, errors.New("couldn't reach it")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got %v", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, monthFileLimit+1))
+	if err != nil {
+		return nil, errors.New("it stopped halfway through")
+	}
+	if len(data) > monthFileLimit {
+		return nil, errors.New("it's too big for a music month")
+	}
+	return data, nil
+}
+
+// parseMonth reads and checks a music month file, returning everything wrong with it
+func parseMonth(data []byte) (month, []string) {
+	var m month
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&m); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr):
+			return m, []string{fmt.Sprintf("line %d: %v", lineAt(data, syntaxErr.Offset), syntaxErr)}
+		case errors.As(err, &typeErr):
+			return m, []string{fmt.Sprintf("line %d: expected %v for %v, not %v", lineAt(data, typeErr.Offset), typeErr.Type, typeErr.Field, typeErr.Value)}
+		}
+		return m, []string{strings.TrimPrefix(err.Error(), "json: ")}
+	}
+	return m, checkMonth(m, dayLines(data))
+}
+
+// dayLines finds the line each day starts on in a month file, so problems can point at them
+func dayLines(data []byte) []int {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if t, err := decoder.Token(); err != nil || t != json.Delim('{') {
+		return nil
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return nil
+		}
+		var value json.RawMessage
+		if name, _ := key.(string); !strings.EqualFold(name, "days") {
+			if decoder.Decode(&value) != nil {
+				return nil
+			}
+			continue
+		}
+		if t, err := decoder.Token(); err != nil || t != json.Delim('[') {
+			return nil
+		}
+		var lines []int
+		for decoder.More() {
+			if decoder.Decode(&value) != nil {
+				return nil
+			}
+			lines = append(lines, lineAt(data, decoder.InputOffset()-int64(len(value))))
+		}
+		return lines
+	}
+	return nil
+}
+
+// lineAt gets the line number a byte offset into a file is on
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// checkMonth makes sure a music month has a prompt for every day, using lines to point at problems
+func checkMonth(m month, lines []int) []string {
+	var problems []string
+	at := func(n int) string {
+		if n < len(lines) {
+			return "line " + strconv.Itoa(lines[n]) + ": "
+		}
+		return ""
+	}
+	length := 0
+	switch {
+	case m.StartTime.IsZero():
+		problems = append(problems, "start_time is missing")
+	case m.StartTime.Day() != 1:
+		problems = append(problems, fmt.Sprintf("start_time is %v, which isn't the first of a month", m.StartTime.Format(prettyDateFormat)))
+	default:
+		length = monthLength(m.StartTime)
+	}
+
+	seen := map[int]bool{}
+	for n, d := range m.Days {
+		if length != 0 && (d.Day < 1 || d.Day > length) {
+			problems = append(problems, fmt.Sprintf("%vday %d isn't in %v, which has %d days", at(n), d.Day, m.StartTime.Format("January 2006"), length))
+			continue
+		}
+		if length == 0 && d.Day < 1 {
+			problems = append(problems, fmt.Sprintf("%vday %d isn't a day of the month", at(n), d.Day))
+			continue
+		}
+		if seen[d.Day] {
+			problems = append(problems, fmt.Sprintf("%vday %d is in there more than once", at(n), d.Day))
+		}
+		seen[d.Day] = true
+		if strings.TrimSpace(d.Prompt) == "" {
+			problems = append(problems, fmt.Sprintf("%vday %d has an empty prompt", at(n), d.Day))
+		}
+	}
+	var missing []string
+	for day := 1; day <= length; day++ {
+		if !seen[day] {
+			missing = append(missing, strconv.Itoa(day))
+		}
+	}
+	if len(missing) == 1 {
+		problems = append(problems, "day "+missing[0]+" has no prompt")
+	} else if len(missing) > 1 {
+		problems = append(problems, "days "+strings.Join(missing, ", ")+" have no prompts")
+	}
+	return problems
+}
+
+// monthLength is how many days there are in the month starting at start
+func monthLength(start time.Time) int {
+	return time.Date(start.Year(), start.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// problemList lays out what's wrong with something
+func problemList(heading string, problems []string) string {
+	lines := make([]string, len(problems))
+	for n, problem := range problems {
+		lines[n] = "- " + problem
+	}
+	return limitLines(heading, lines, andMore)
+}
+
+// monthPreview shows a music month's prompts before it's saved
+func monthPreview(m month) string {
+	days := append([]day(nil), m.Days...)
+	sort.Slice(days, func(a, b int) bool { return days[a].Day < days[b].Day })
+	lines := make([]string, len(days))
+	for n, d := range days {
+		lines[n] = strconv.Itoa(d.Day) + ": " + d.Prompt
+	}
+	return limitLines("Here's the music month beginning on "+m.StartTime.Format(prettyDateFormat)+". Save it?", lines, andMore)
+}
+
+// organiserOnly checks whoever clicked can run music months, telling them if not
+func organiserOnly(ctx context.Context, s botSession, i *discordgo.InteractionCreate) bool {
+	allowed, err := hasCapability(ctx, i.GuildID, i.Member, capMusicOrganiser)
+	if err != nil {
+		report(s, i, fmt.Errorf("checking permissions: %v", err))
+	}
+	if !allowed {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags:   64,
+				Content: "You need the " + strings.ToLower(capabilityTitle(capMusicOrganiser)) + " permission to do that",
+			},
+		})
+	}
+	return allowed
+}
+
+// saveMonthSetup saves the month attached to a /musicsetup preview, checking it again on the way
+func saveMonthSetup(ctx context.Context, s botSession, i *discordgo.InteractionCreate, args []string) {
+	if db == nil {
+		respondQuietly(s, i, "I haven't been set up to allow music months, please moan at whoever set me up")
+		return
+	}
+	if !organiserOnly(ctx, s, i) {
+		return
+	}
+	var fileURL string
+	if i.Message != nil {
+		for _, a := range i.Message.Attachments {
+			if a.Filename == monthFileName {
+				fileURL = a.URL
+			}
+		}
+	}
+	if fileURL == "" {
+		report(s, i, errors.New("music month preview has no month attached"))
+		return
+	}
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+	finish := func(content string) {
+		if ctx.Err() == context.DeadlineExceeded {
+			content = timeoutMessage
+		}
+		s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+			Content:    &content,
+			Components: &[]discordgo.MessageComponent{},
+		})
+	}
+	data, err := fetchMonthFile(ctx, fileURL)
+	if err != nil {
+		finish("Couldn't get the month back from Discord (" + err.Error() + "), so I haven't saved it. Try /musicsetup again")
+		return
+	}
+	m, problems := parseMonth(data)
+	if len(problems) > 0 {
+		finish(problemList("That month has problems, so I haven't saved it:", problems))
+		return
+	}
+	m.GuildID = i.GuildID
+	monthName := m.StartTime.Format("Jan 2006")
+	// Uploading a month again replaces it, rather than leaving two for the same month
+	existing, err := storedMonth(ctx, i.GuildID, monthName)
+	switch {
+	case err == errNotFound:
+		err = db.AddMonth(ctx, m)
+	case err == nil:
+		m.ID = existing.ID
+		err = db.UpdateMonth(ctx, m)
+	}
+	if err != nil {
+		finish("Something went wrong at my end so I didn't save the month")
+		report(s, i, fmt.Errorf("saving music month: %v", err))
+		return
+	}
+	if existing != nil {
+		recordMonthChange(ctx, s, i, monthName, "replaced "+monthName+" with an upload of "+promptCount(len(m.Days)))
+		finish("Okay, I've replaced the music month beginning on " + m.StartTime.Format(prettyDateFormat))
+		return
+	}
+	recordMonthChange(ctx, s, i, monthName, "set up "+monthName+" with "+promptCount(len(m.Days)))
+	finish("Okay, I've set up a music month beginning on " + m.StartTime.Format(prettyDateFormat))
+}
+
+// cancelMonthSetup drops a /musicsetup preview without saving it
+func cancelMonthSetup(ctx context.Context, s botSession, i *discordgo.InteractionCreate, args []string) {
+	if !organiserOnly(ctx, s, i) {
+		return
+	}
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    "Okay, I haven't saved that month",
+			Components: []discordgo.MessageComponent{},
+		},
+	})
+}
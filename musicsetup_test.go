@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// monthJSON builds a month file starting on start with a prompt for each of days
+func monthJSON(start string, days int) string {
+	var lines []string
+	for day := 1; day <= days; day++ {
+		lines = append(lines, fmt.Sprintf(`    {"day": %d, "prompt": "prompt %d"}`, day, day))
+	}
+	return "{\n  \"start_time\": \"" + start + "\",\n  \"days\": [\n" + strings.Join(lines, ",\n") + "\n  ]\n}"
+}
+
+func TestParseMonth(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want []string
+	}{
+		{name: "fine", file: monthJSON("2024-02-01T00:00:00Z", 29)},
+		{name: "bad JSON", file: "{\n  \"start_time\": \"2024-02-01T00:00:00Z\",\n  \"days\": [,]\n}", want: []string{"line 3: invalid character ','"}},
+		{name: "wrong type", file: "{\n  \"days\": [\n    {\"day\": \"one\"}\n  ]\n}", want: []string{"line 3: expected int for days", "not string"}},
+		{name: "unknown field", file: `{"start_time": "2024-02-01T00:00:00Z", "promtps": []}`, want: []string{`unknown field "promtps"`}},
+		{name: "no start", file: `{"days": [{"day": 1, "prompt": "birds"}]}`, want: []string{"start_time is missing"}},
+		{name: "not the first", file: monthJSON("2024-02-03T00:00:00Z", 29), want: []string{"start_time is February 3, 2024"}},
+		{name: "short month", file: monthJSON("2023-02-01T00:00:00Z", 29), want: []string{"line 32: day 29 isn't in February 2023, which has 28 days"}},
+		{name: "missing days", file: monthJSON("2024-03-01T00:00:00Z", 29), want: []string{"days 30, 31 have no prompts"}},
+		{
+			name: "duplicate and empty",
+			file: "{\n  \"start_time\": \"2024-02-01T00:00:00Z\",\n  \"days\": [\n    {\"day\": 1, \"prompt\": \"birds\"},\n    {\"day\": 1, \"prompt\": \" \"}\n  ]\n}",
+			want: []string{"line 5: day 1 is in there more than once", "line 5: day 1 has an empty prompt", "days 2, 3,"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, problems := parseMonth([]byte(test.file))
+			if len(test.want) == 0 && len(problems) != 0 {
+				t.Fatalf("Got problems %q with a fine month", problems)
+			}
+			all := strings.Join(problems, "\n")
+			for _, want := range test.want {
+				if !strings.Contains(all, want) {
+					t.Errorf("Problems %q don't mention %q", problems, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMusicSetupPreviewAndSave(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	db.AddGrant(ctx, grant{GuildID: "1", Capability: capMusicOrganiser, UserID: "100"})
+	files := map[string]string{"/upload.json": monthJSON("2024-02-01T00:00:00Z", 29)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, file)
+	}))
+	defer server.Close()
+
+	i := slashCommand("musicsetup", &discordgo.ApplicationCommandInteractionDataOption{Name: "attachment", Type: discordgo.ApplicationCommandOptionAttachment, Value: "40"})
+	i.Data = discordgo.ApplicationCommandInteractionData{Name: "musicsetup", Options: i.ApplicationCommandData().Options, Resolved: &discordgo.ApplicationCommandInteractionDataResolved{
+		Attachments: map[string]*discordgo.MessageAttachment{"40": {ID: "40", Filename: "february.json", URL: server.URL + "/upload.json", Size: len(files["/upload.json"])}},
+	}}
+	fake := newFakeSession()
+	handleInteraction(ctx, fake, i)
+	if len(fake.responseEditData) != 2 {
+		t.Fatalf("Got edits %q, want progress then a preview", fake.responseEdits)
+	}
+	preview := fake.responseEditData[1]
+	if !strings.HasPrefix(*preview.Content, "Here's the music month beginning on February 1, 2024") || !strings.Contains(*preview.Content, "\n29: prompt 29") {
+		t.Errorf("Got preview %q", *preview.Content)
+	}
+	if len(preview.Files) != 1 || preview.Components == nil || len(*preview.Components) != 1 {
+		t.Fatalf("Preview has no month attached or no buttons: %+v", preview)
+	}
+	if _, err := db.LatestMonth(ctx, "1", time.Now()); err != errNotFound {
+		t.Fatalf("Month was saved before it was confirmed: %v", err)
+	}
+
+	checked, _ := ioutil.ReadAll(preview.Files[0].Reader)
+	files["/checked.json"] = string(checked)
+	save := buttonClick(&discordgo.MessageSend{Components: *preview.Components}, "Save")
+	save.GuildID, save.User, save.Member = "1", nil, i.Member
+	save.Message.Attachments = []*discordgo.MessageAttachment{{Filename: monthFileName, URL: server.URL + "/checked.json"}}
+
+	// Only organisers can save it
+	save.Member = &discordgo.Member{User: &discordgo.User{ID: "101", Username: "kazooie"}}
+	fake = newFakeSession()
+	handleInteraction(ctx, fake, save)
+	if len(fake.responses) != 1 || fake.responses[0].Data.Content != "You need the music organiser permission to do that" {
+		t.Fatalf("Got responses %+v, want a refusal", fake.responses)
+	}
+
+	save.Member = i.Member
+	fake = newFakeSession()
+	handleInteraction(ctx, fake, save)
+	if len(fake.responseEdits) != 1 || fake.responseEdits[0] != "Okay, I've set up a music month beginning on February 1, 2024" {
+		t.Fatalf("Got edits %q, want the month saved", fake.responseEdits)
+	}
+	m, err := db.NextMonth(ctx, "1", time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil || m.GuildID != "1" || len(m.Days) != 29 {
+		t.Errorf("Got month %+v, %v", m, err)
+	}
+
+	// Saving it again replaces it rather than adding another
+	fake = newFakeSession()
+	handleInteraction(ctx, fake, save)
+	if len(fake.responseEdits) != 1 || fake.responseEdits[0] != "Okay, I've replaced the music month beginning on February 1, 2024" {
+		t.Fatalf("Got edits %q, want the month replaced", fake.responseEdits)
+	}
+	if again, err := db.NextMonth(ctx, "1", m.StartTime); err != errNotFound {
+		t.Errorf("Got a second month %+v, %v", again, err)
+	}
+	changes, _ := db.MonthChanges(ctx, "1")
+	if len(changes) != 2 || changes[1].Change != "set up Feb 2024 with 29 prompts" || changes[0].UserID != "100" {
+		t.Errorf("Got changes %+v, want the upload and replacement recorded", changes)
+	}
+}
+
+func TestMusicSetupRefusesBadMonths(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	db.AddGrant(ctx, grant{GuildID: "1", Capability: capMusicOrganiser, UserID: "100"})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/huge.json" {
+			fmt.Fprint(w, strings.Repeat(" ", monthFileLimit+1))
+			return
+		}
+		fmt.Fprint(w, monthJSON("2024-02-01T00:00:00Z", 30))
+	}))
+	defer server.Close()
+
+	for path, want := range map[string]string{
+		"/long.json": "That month has problems, so I haven't saved it:\n- line 33: day 30 isn't in February 2024, which has 29 days",
+		"/huge.json": "Couldn't get the file: it's too big for a music month",
+	} {
+		fake := newFakeSession()
+		handleInteraction(ctx, fake, slashCommand("musicsetup", stringOption("file", server.URL+path)))
+		if len(fake.responseEdits) != 2 || fake.responseEdits[1] != want {
+			t.Errorf("Got edits %q for %v, want %q", fake.responseEdits, path, want)
+		}
+	}
+
+	fake := newFakeSession()
+	handleInteraction(ctx, fake, slashCommand("musicsetup"))
+	if len(fake.responses) != 1 || !strings.HasPrefix(fake.responses[0].Data.Content, "Give me a .json file") {
+		t.Errorf("Got responses %+v, want to be asked for a file", fake.responses)
+	}
+}
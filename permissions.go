@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// capAdmin can't be granted; it's for bot owners, the guild's admin roles and Discord administrators
+	capAdmin          = "admin"
+	capMusicOrganiser = "music_organiser"
+	capRoleManager    = "role_manager"
+	capAnnouncer      = "announcer"
+)
+
+type capability struct {
+	Name        string
+	Title       string
+	Description string
+}
+
+// capabilities are the things that can be handed out with /permissions grant
+var capabilities = []capability{
+	{Name: capMusicOrganiser, Title: "Music organiser", Description: "Set up and run music months"},
+	{Name: capRoleManager, Title: "Role manager", Description: "Add and remove roles for other members"},
+	{Name: capAnnouncer, Title: "Announcer", Description: "Post reminders in channels and ping people with them"},
+}
+
+func capabilityTitle(name string) string {
+	for _, c := range capabilities {
+		if c.Name == name {
+			return c.Title
+		}
+	}
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isAdmin is true for bot owners, members with one of the guild's admin roles and Discord administrators
+func isAdmin(guildID string, member *discordgo.Member) bool {
+	if member == nil || member.User == nil {
+		return false
+	}
+	if contains(conf.Owners, member.User.ID) {
+		return true
+	}
+	if member.Permissions&discordgo.PermissionAdministrator != 0 {
+		return true
+	}
+	for _, roleID := range conf.Guilds[guildID].AdminRoles {
+		if contains(member.Roles, roleID) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasCapability checks whether a member has been granted a capability, either directly or through a role
+func hasCapability(ctx context.Context, guildID string, member *discordgo.Member, name string) (bool, error) {
+	if isAdmin(guildID, member) {
+		return true, nil
+	}
+	if name == capAdmin || db == nil || member == nil || member.User == nil {
+		return false, nil
+	}
+	grants, err := db.Grants(ctx, guildID)
+	if err != nil {
+		return false, err
+	}
+	for _, g := range grants {
+		if g.Capability != name {
+			continue
+		}
+		if (g.UserID != "" && g.UserID == member.User.ID) || (g.RoleID != "" && contains(member.Roles, g.RoleID)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// requires wraps a handler so only members with the capability can use it
+func requires(name string, h commandHandler) commandHandler {
+	return func(ctx context.Context, s botSession, i *discordgo.InteractionCreate, opts commandOptions) {
+		allowed, err := hasCapability(ctx, i.GuildID, i.Member, name)
+		if err != nil {
+			report(s, i, fmt.Errorf("checking permissions: %v", err))
+		}
+		if !allowed {
+			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseChannelMessageWithSource,
+				Data: &discordgo.InteractionResponseData{
+					Flags:   64,
+					Content: "You need the " + strings.ToLower(capabilityTitle(name)) + " permission to do that",
+				},
+			})
+			return
+		}
+		h(ctx, s, i, opts)
+	}
+}
+
+func capabilityChoices() []*discordgo.ApplicationCommandOptionChoice {
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(capabilities))
+	for _, c := range capabilities {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: c.Title + " - " + c.Description, Value: c.Name})
+	}
+	return choices
+}
+
+func grantOptions() []*discordgo.ApplicationCommandOption {
+	return []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "capability",
+			Description: "What they should be able to do",
+			Required:    true,
+			Choices:     capabilityChoices(),
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionRole,
+			Name:        "role",
+			Description: "Everyone with this role",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionUser,
+			Name:        "user",
+			Description: "Just this person",
+		},
+	}
+}
+
+var permissionsCommand = &command{
+	Name:        "permissions",
+	Description: "Choose who can organise music months, manage roles and so on",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "grant",
+			Description: "Let a role or user do something",
+			Options:     grantOptions(),
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "revoke",
+			Description: "Stop a role or user doing something",
+			Options:     grantOptions(),
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "list",
+			Description: "See who can do what",
+		},
+	},
+	Handler: permissionsHandler,
+}
+
+func mentionGrant(g grant) string {
+	if g.RoleID != "" {
+		return "<@&" + g.RoleID + ">"
+	}
+	return "<@" + g.UserID + ">"
+}
+
+// respondQuietly responds without pinging anyone mentioned in the content
+func respondQuietly(s botSession, i *discordgo.InteractionCreate, content string) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content:         content,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		},
+	})
+}
+
+func permissionsHandler(ctx context.Context, s botSession, i *discordgo.InteractionCreate, opts commandOptions) {
+	if db == nil {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "I haven't been set up to store permissions, please moan at whoever set me up",
+			},
+		})
+		return
+	}
+	if opts.Subcommand() == "list" {
+		listPermissions(ctx, s, i)
+		return
+	}
+	if !isAdmin(i.GuildID, i.Member) {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags:   64,
+				Content: "Only admins can change permissions",
+			},
+		})
+		return
+	}
+
+	g := grant{GuildID: i.GuildID, Capability: opts.String("capability")}
+	if opts.Has("role") {
+		g.RoleID = opts.Role("role").ID
+	}
+	if opts.Has("user") {
+		g.UserID = opts.User("user").ID
+	}
+	if (g.RoleID == "") == (g.UserID == "") {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags:   64,
+				Content: "Pick either a role or a user",
+			},
+		})
+		return
+	}
+
+	var err error
+	var response string
+	if opts.Subcommand() == "grant" {
+		err = db.AddGrant(ctx, g)
+		response = "Okay, " + mentionGrant(g) + " now has the " + strings.ToLower(capabilityTitle(g.Capability)) + " permission"
+	} else {
+		err = db.RemoveGrant(ctx, g)
+		response = "Okay, " + mentionGrant(g) + " no longer has the " + strings.ToLower(capabilityTitle(g.Capability)) + " permission"
+		if err == errNotFound {
+			err = nil
+			response = mentionGrant(g) + " didn't have the " + strings.ToLower(capabilityTitle(g.Capability)) + " permission anyway"
+		}
+	}
+	if err != nil {
+		report(s, i, fmt.Errorf("saving permissions: %v", err))
+		response = "Something went wrong at my end so I didn't change any permissions"
+	}
+	respondQuietly(s, i, response)
+}
+
+func listPermissions(ctx context.Context, s botSession, i *discordgo.InteractionCreate) {
+	grants, err := db.Grants(ctx, i.GuildID)
+	if err != nil {
+		report(s, i, fmt.Errorf("getting permissions: %v", err))
+		respondQuietly(s, i, "Something went wrong at my end so I can't list permissions")
+		return
+	}
+
+	var response strings.Builder
+	response.WriteString("Admins: Discord administrators")
+	for _, owner := range conf.Owners {
+		response.WriteString(", <@" + owner + ">")
+	}
+	for _, roleID := range conf.Guilds[i.GuildID].AdminRoles {
+		response.WriteString(", <@&" + roleID + ">")
+	}
+	response.WriteString("\n")
+	for _, c := range capabilities {
+		var holders []string
+		for _, g := range grants {
+			if g.Capability == c.Name {
+				holders = append(holders, mentionGrant(g))
+			}
+		}
+		if len(holders) == 0 {
+			holders = []string{"just admins"}
+		}
+		response.WriteString(c.Title + ": " + strings.Join(holders, ", ") + "\n")
+	}
+	respondQuietly(s, i, response.String())
+}
+
+// roleHasGrants says whether any capability has been granted to a role
+func roleHasGrants(ctx context.Context, guildID, roleID string) (bool, error) {
+	if db == nil {
+		return false, nil
+	}
+	grants, err := db.Grants(ctx, guildID)
+	if err != nil {
+		return false, err
+	}
+	for _, g := range grants {
+		if g.RoleID == roleID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// roleTarget works out whose roles addrole and removerole should change. Changing someone
+// else's roles needs the role manager permission, and only admins can hand out admin roles
+// or roles that carry bot permissions
+func roleTarget(ctx context.Context, s botSession, i *discordgo.InteractionCreate, opts commandOptions, role *discordgo.Role) (string, bool) {
+	userID := i.Member.User.ID
+	if opts.Has("member") {
+		userID = opts.User("member").ID
+	}
+
+	refusal := ""
+	if userID != i.Member.User.ID {
+		allowed, err := hasCapability(ctx, i.GuildID, i.Member, capRoleManager)
+		if err != nil {
+			report(s, i, fmt.Errorf("checking permissions: %v", err))
+		}
+		if !allowed {
+			refusal = "You need the role manager permission to change other people's roles"
+		}
+	}
+	if refusal == "" && !isAdmin(i.GuildID, i.Member) {
+		granted, err := roleHasGrants(ctx, i.GuildID, role.ID)
+		if err != nil {
+			report(s, i, fmt.Errorf("checking role grants: %v", err))
+			refusal = "Something went wrong at my end so I couldn't check you're allowed to do that"
+		} else if granted || contains(conf.Guilds[i.GuildID].AdminRoles, role.ID) {
+			refusal = "Only admins can hand out `" + role.Name + "`"
+		}
+	}
+	if refusal != "" {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags:   64,
+				Content: refusal,
+			},
+		})
+		return "", false
+	}
+	return userID, true
+}
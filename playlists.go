@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"google.golang.org/api/youtube/v3"
+)
+
+// playlistLease is how long an instance has to build a playlist it's claimed. Builds taking
+// longer are given up on, and the job can be claimed by someone else
+const playlistLease = 10 * time.Minute
+
+// playlistResync is how often the store is checked for jobs, for ones queued by other
+// instances or left behind by one that stopped partway
+const playlistResync = 5 * time.Minute
+
+// musicPlaylistCommand queues a playlist of a month's picks to be built in the background,
+// since keeping one up to date takes far more YouTube calls than fit in an interaction
+var musicPlaylistCommand = &command{
+	Name:        "musicplaylist",
+	Description: "Create/retrieve a playlist of your songs for the most recent music month",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionBoolean,
+			Name:        "mine",
+			Description: "Whether you want the whole server's songs or just your own",
+			Required:    true,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionInteger,
+			Name:        "day",
+			Description: "Which day's songs to retrieve (returns every day if empty)",
+			Required:    false,
+		},
+	},
+	Timeout: 15 * time.Second,
+	Handler: musicPlaylistHandler,
+}
+
+func musicPlaylistHandler(ctx context.Context, s botSession, i *discordgo.InteractionCreate, opts commandOptions) {
+	if db == nil {
+		respondQuietly(s, i, "I haven't been set up to allow music months, please moan at whoever set me up")
+		return
+	}
+	deferResponse(s, i)
+	retrievedMonth, err := db.LatestMonth(ctx, i.GuildID, time.Now().UTC())
+	if err != nil {
+		if err != errNotFound {
+			report(s, i, fmt.Errorf("getting music month: %v", err))
+		}
+		editResponse(ctx, s, i, "No music month past or present found")
+		return
+	}
+	monthName := retrievedMonth.StartTime.Format("Jan 2006")
+	day := 0
+	if opts.Has("day") {
+		day = opts.Int("day")
+	}
+	var userID, username string
+	if opts.Bool("mine") {
+		userID, username = i.Member.User.ID, i.Member.User.Username
+	}
+
+	if userID != "" && day != 0 {
+		// Don't make a playlist for one song for one person!
+		songs, err := db.Songs(ctx, i.GuildID, monthName, userID, day)
+		if err != nil {
+			editResponse(ctx, s, i, "Something went wrong at my end so I couldn't find your pick")
+			report(s, i, fmt.Errorf("getting songs: %v", err))
+			return
+		}
+		if len(songs) == 0 {
+			editResponse(ctx, s, i, "I have no pick saved for you for day "+strconv.Itoa(day)+" of "+monthName)
+			return
+		}
+		editResponse(ctx, s, i, "Your pick for day "+strconv.Itoa(day)+" of "+monthName+" was "+songs[0].Song)
+		return
+	}
+
+	if youtubeClient == nil {
+		editResponse(ctx, s, i, "I haven't been set up to make playlists, please moan at whoever set me up")
+		return
+	}
+	// Say so before queueing it, so this can't overwrite the link if it's built quickly
+	editResponse(ctx, s, i, "That playlist's in the queue, I'll put the link here when it's ready")
+	_, err = db.QueuePlaylistJob(ctx, playlistJob{
+		ID:       playlistJobID(i.GuildID, monthName, userID, day),
+		GuildID:  i.GuildID,
+		Month:    monthName,
+		UserID:   userID,
+		Username: username,
+		Day:      day,
+		Queued:   time.Now(),
+		Waiters:  []playlistWaiter{{AppID: i.AppID, Token: i.Token, ChannelID: i.ChannelID, UserID: i.Member.User.ID}},
+	})
+	if err != nil {
+		editResponse(ctx, s, i, "Something went wrong at my end so I couldn't queue that playlist")
+		report(s, i, fmt.Errorf("queueing playlist: %v", err))
+		return
+	}
+	playlistJobs.Wake()
+}
+
+// playlistJobs builds queued playlists one at a time, so a rush of requests doesn't use up
+// the YouTube quota all at once
+var playlistJobs = newPlaylistWorker()
+
+type playlistWorker struct {
+	wake chan struct{}
+}
+
+func newPlaylistWorker() *playlistWorker {
+	return &playlistWorker{wake: make(chan struct{}, 1)}
+}
+
+// Wake has Run check for jobs now rather than at the next resync
+func (w *playlistWorker) Wake() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run builds playlists as they're queued until ctx is cancelled, checking the store when it
+// starts and every playlistResync after
+func (w *playlistWorker) Run(ctx context.Context, session botSession) {
+	resync := time.NewTicker(playlistResync)
+	defer resync.Stop()
+	for {
+		runPlaylistJobs(ctx, session)
+		select {
+		case <-ctx.Done():
+			return
+		case <-resync.C:
+		case <-w.wake:
+		}
+	}
+}
+
+// runPlaylistJobs runs every pending job, oldest first
+func runPlaylistJobs(ctx context.Context, s botSession) {
+	jobs, err := db.PendingPlaylistJobs(ctx)
+	if err != nil {
+		log.Printf("Couldn't get queued playlists: %v", err)
+		return
+	}
+	for _, j := range jobs {
+		if ctx.Err() != nil {
+			return
+		}
+		runPlaylistJob(ctx, s, j.ID, time.Now())
+	}
+}
+
+// runPlaylistJob builds a job's playlist and tells everyone waiting on it how it went
+func runPlaylistJob(ctx context.Context, s botSession, id string, now time.Time) {
+	// Claim it first so no other instance builds it too. If someone else has it, they'll
+	// finish it, or their lease will run out and a resync will pick it up here
+	j, err := db.ClaimPlaylistJob(ctx, id, instanceID, now, now.Add(playlistLease))
+	if err == errNotClaimed {
+		return
+	}
+	if err != nil {
+		log.Printf("Couldn't claim playlist job %v: %v", id, err)
+		return
+	}
+	for _, w := range j.Waiters {
+		tellWaiter(s, w, "Building that playlist now, I'll put the link here when it's ready", false)
+	}
+
+	buildCtx, cancel := context.WithDeadline(ctx, now.Add(playlistLease))
+	result, err := updateAndCreatePlaylist(buildCtx, j.GuildID, j.Month, j.UserID, j.Username, j.Day)
+	cancel()
+	if ctx.Err() != nil {
+		// Shutting down, so leave it for the lease to run out and whoever's next to build it
+		return
+	}
+	status := jobDone
+	if err != nil {
+		log.Printf("Couldn't build playlist job %v: %v", id, err)
+		notifyAdmins(s, j.GuildID, "I couldn't build the "+j.Month+" playlist: "+err.Error())
+		status, result = jobFailed, "Something went wrong at my end building that playlist, sorry. Try again in a bit"
+	}
+
+	finished, err := db.FinishPlaylistJob(ctx, id, instanceID, status, result)
+	if err == errLeaseLost {
+		// Whoever took over will tell everyone when they're done
+		log.Printf("Took too long building playlist job %v, so it's been claimed by someone else", id)
+		return
+	}
+	if err != nil {
+		log.Printf("Couldn't finish playlist job %v: %v", id, err)
+		return
+	}
+	// Anyone who joined while it was being built is on the finished job too
+	for _, w := range finished.Waiters {
+		tellWaiter(s, w, result, true)
+	}
+}
+
+// tellWaiter edits someone's /musicplaylist answer. Discord only allows that for 15 minutes, so
+// if it's final and the edit fails they're mentioned in the channel they asked in instead
+func tellWaiter(s botSession, w playlistWaiter, message string, final bool) {
+	_, err := s.InteractionResponseEdit(&discordgo.Interaction{AppID: w.AppID, Token: w.Token, ChannelID: w.ChannelID}, &discordgo.WebhookEdit{Content: &message})
+	if err == nil || !final {
+		return
+	}
+	_, err = s.ChannelMessageSendComplex(w.ChannelID, &discordgo.MessageSend{
+		Content:         "<@" + w.UserID + "> " + message,
+		AllowedMentions: &discordgo.MessageAllowedMentions{Users: []string{w.UserID}},
+	})
+	if err != nil {
+		log.Printf("Couldn't tell %v about their playlist: %v", w.UserID, err)
+	}
+}
+
+// updateAndCreatePlaylist makes sure a YouTube playlist has exactly the songs picked for it,
+// creating it if it's new, and returns what to tell whoever asked for it
+func updateAndCreatePlaylist(ctx context.Context, guildID, monthName, userID, username string, day int) (string, error) {
+	settings := conf.guild(guildID)
+	var playlistTitle string
+	var playlistDescription string
+	if userID == "" {
+		if day == 0 {
+			playlistTitle = settings.PlaylistTitlePrefix + monthName
+			playlistDescription = "All the songs posted for " + monthName + "'s music month in " + settings.Community
+		} else {
+			playlistTitle = settings.PlaylistTitlePrefix + monthName + " Day " + strconv.Itoa(day)
+			playlistDescription = "All the songs posted on day " + strconv.Itoa(day) + " of " + monthName + "'s music month in " + settings.Community
+		}
+	} else {
+		playlistTitle = settings.PlaylistTitlePrefix + monthName + " - " + username
+		playlistDescription = "All the songs posted by " + username + " for " + monthName + "'s music month in " + settings.Community
+	}
+	songs, err := db.Songs(ctx, guildID, monthName, userID, day)
+	if err != nil {
+		return "", fmt.Errorf("getting songs: %v", err)
+	}
+
+	if len(songs) == 0 {
+		if userID == "" {
+			if day == 0 {
+				return "No-one has submitted any songs for " + monthName, nil
+			}
+			return "No-one has submitted any songs for day " + strconv.Itoa(day) + " of " + monthName, nil
+		}
+		return "You haven't submitted any songs for " + monthName, nil
+	}
+
+	playlistID := ""
+	existing, err := db.Playlist(ctx, guildID, monthName, userID, day)
+	if err == errNotFound {
+		// Create a new playlist
+		insertPlaylist := &youtube.Playlist{
+			Snippet: &youtube.PlaylistSnippet{
+				Title:       playlistTitle,
+				Description: playlistDescription,
+			},
+			Status: &youtube.PlaylistStatus{PrivacyStatus: "unlisted"},
+		}
+		part := []string{"snippet", "status"}
+		call := youtubeClient.Playlists.Insert(part, insertPlaylist)
+		response, err := call.Context(ctx).Do()
+		if err != nil {
+			return "", fmt.Errorf("creating a playlist: %v", err)
+		}
+		err = db.AddPlaylist(ctx, playlist{
+			GuildID:    guildID,
+			UserID:     userID,
+			Month:      monthName,
+			Day:        day,
+			PlaylistID: response.Id,
+		})
+		if err != nil {
+			log.Printf("Error saving record: %v", err)
+		}
+
+		playlistID = response.Id
+	} else if err != nil {
+		return "", fmt.Errorf("getting a playlist: %v", err)
+	} else {
+		playlistID = existing.PlaylistID
+	}
+
+	// Check all the songs on the playlist match the songs we have saved, and insert/delete as appropriate
+	pageToken := ""
+	var playlistVideos []*youtube.PlaylistItem
+	for {
+		part := []string{"contentDetails"}
+		call := youtubeClient.PlaylistItems.List(part)
+		call = call.PlaylistId(playlistID)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		response, err := call.Context(ctx).Do()
+		if err != nil {
+			return "", fmt.Errorf("listing a playlist: %v", err)
+		}
+
+		playlistVideos = append(playlistVideos, response.Items...)
+		pageToken = response.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+
+	// Picks from before links were checked may not parse, and other providers can't go in
+	videoIDs := map[string]bool{}
+	for _, gcpsong := range songs {
+		if link, err := parseSongLink(gcpsong.Song); err == nil && link.Provider == providerYouTube {
+			videoIDs[link.ID] = true
+		}
+	}
+
+	// Check the songs we have our end are in the playlist and add if necessary
+	for gcpID := range videoIDs {
+		inPlaylist := false
+		for _, ytsong := range playlistVideos {
+			if gcpID == ytsong.ContentDetails.VideoId {
+				inPlaylist = true
+				break
+			}
+		}
+		if !inPlaylist {
+			part := []string{"snippet"}
+			video := &youtube.PlaylistItem{
+				Snippet: &youtube.PlaylistItemSnippet{
+					PlaylistId: playlistID,
+					ResourceId: &youtube.ResourceId{
+						Kind:    "youtube#video",
+						VideoId: gcpID,
+					},
+				},
+			}
+			call := youtubeClient.PlaylistItems.Insert(part, video)
+			_, err := call.Context(ctx).Do()
+			if err != nil {
+				return "", fmt.Errorf("updating a playlist: %v", err)
+			}
+		}
+	}
+
+	// Check the songs we have on YouTube's end are in GCP and delete if necessary
+	for _, ytsong := range playlistVideos {
+		if !videoIDs[ytsong.ContentDetails.VideoId] {
+			call := youtubeClient.PlaylistItems.Delete(ytsong.Id)
+			err := call.Context(ctx).Do()
+			if err != nil {
+				return "", fmt.Errorf("updating a playlist: %v", err)
+			}
+		}
+	}
+
+	if day == 0 {
+		return "Playlist for " + monthName + ": https://youtube.com/playlist?list=" + playlistID, nil
+	}
+	return "Playlist for " + monthName + " Day " + strconv.Itoa(day) + ": https://youtube.com/playlist?list=" + playlistID, nil
+}
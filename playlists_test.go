@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"google.golang.org/api/option"
+	"google.golang.org/api/youtube/v3"
+)
+
+// fakeYouTube is just enough of the YouTube API to build playlists with
+type fakeYouTube struct {
+	mu        sync.Mutex
+	playlists map[string][]string
+	calls     int
+}
+
+func (f *fakeYouTube) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/youtube/v3/playlists":
+		id := "PL" + string(rune('A'+len(f.playlists)))
+		f.playlists[id] = nil
+		json.NewEncoder(w).Encode(youtube.Playlist{Id: id})
+	case r.Method == http.MethodGet && r.URL.Path == "/youtube/v3/playlistItems":
+		id := r.URL.Query().Get("playlistId")
+		var list youtube.PlaylistItemListResponse
+		for _, video := range f.playlists[id] {
+			list.Items = append(list.Items, &youtube.PlaylistItem{Id: id + "/" + video, ContentDetails: &youtube.PlaylistItemContentDetails{VideoId: video}})
+		}
+		json.NewEncoder(w).Encode(list)
+	case r.Method == http.MethodPost && r.URL.Path == "/youtube/v3/playlistItems":
+		var item youtube.PlaylistItem
+		json.NewDecoder(r.Body).Decode(&item)
+		id := item.Snippet.PlaylistId
+		f.playlists[id] = append(f.playlists[id], item.Snippet.ResourceId.VideoId)
+		json.NewEncoder(w).Encode(item)
+	case r.Method == http.MethodDelete && r.URL.Path == "/youtube/v3/playlistItems":
+		parts := strings.SplitN(r.URL.Query().Get("id"), "/", 2)
+		var kept []string
+		for _, video := range f.playlists[parts[0]] {
+			if video != parts[1] {
+				kept = append(kept, video)
+			}
+		}
+		f.playlists[parts[0]] = kept
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// useFakeYouTube points youtubeClient at a fakeYouTube for the length of a test
+func useFakeYouTube(t *testing.T) *fakeYouTube {
+	t.Helper()
+	fake := &fakeYouTube{playlists: map[string][]string{}}
+	server := httptest.NewServer(fake)
+	client, err := youtube.NewService(context.Background(), option.WithEndpoint(server.URL+"/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	youtubeClient = client
+	t.Cleanup(func() {
+		youtubeClient = nil
+		server.Close()
+	})
+	return fake
+}
+
+func mineOption(mine bool) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: "mine", Type: discordgo.ApplicationCommandOptionBoolean, Value: mine}
+}
+
+func TestMusicPlaylistIsBuiltInTheBackground(t *testing.T) {
+	useTestBot(t)
+	yt := useFakeYouTube(t)
+	ctx := context.Background()
+	db.AddMonth(ctx, month{GuildID: "1", StartTime: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC), Days: []day{{Day: 1, Prompt: "birds"}}})
+	db.SaveSong(ctx, song{GuildID: "1", UserID: "100", Month: "Jan 2020", Day: 1, Song: "https://www.youtube.com/watch?v=dQw4w9WgXcQ"})
+	db.SaveSong(ctx, song{GuildID: "1", UserID: "101", Month: "Jan 2020", Day: 1, Song: "https://youtu.be/9bZkp7q19f0"})
+	db.SaveSong(ctx, song{GuildID: "1", UserID: "102", Month: "Jan 2020", Day: 1, Song: "https://open.spotify.com/track/4cOdK2wGLETKBW3PvgPWqT"})
+
+	// Two people asking for the same playlist share a job, and nothing's built yet
+	for _, userID := range []string{"100", "101"} {
+		i := slashCommand("musicplaylist", mineOption(false))
+		i.Member.User.ID, i.Token = userID, "token-"+userID
+		fake := newFakeSession()
+		handleInteraction(ctx, fake, i)
+		if len(fake.responseEdits) != 1 || !strings.HasPrefix(fake.responseEdits[0], "That playlist's in the queue") {
+			t.Fatalf("Got edits %q, want the playlist queued", fake.responseEdits)
+		}
+	}
+	if yt.calls != 0 {
+		t.Errorf("Made %d YouTube calls while answering, want none", yt.calls)
+	}
+	pending, _ := db.PendingPlaylistJobs(ctx)
+	if len(pending) != 1 || len(pending[0].Waiters) != 2 {
+		t.Fatalf("Got pending jobs %+v, want one shared job", pending)
+	}
+
+	worker := newFakeSession()
+	runPlaylistJobs(ctx, worker)
+	if videos := yt.playlists["PLA"]; len(videos) != 2 || videos[0] == videos[1] {
+		t.Errorf("Got playlist %q, want both YouTube picks", videos)
+	}
+	want := "Playlist for Jan 2020: https://youtube.com/playlist?list=PLA"
+	if len(worker.responseEdits) != 4 || worker.responseEdits[2] != want || worker.responseEdits[3] != want {
+		t.Errorf("Got edits %q, want both told when it's built", worker.responseEdits)
+	}
+	if pending, _ := db.PendingPlaylistJobs(ctx); len(pending) != 0 {
+		t.Errorf("Got pending jobs %+v after running them", pending)
+	}
+
+	// Asking again brings the same playlist up to date
+	db.SaveSong(ctx, song{GuildID: "1", UserID: "101", Month: "Jan 2020", Day: 1, Song: "https://youtu.be/kJQP7kiw5Fk"})
+	handleInteraction(ctx, newFakeSession(), slashCommand("musicplaylist", mineOption(false)))
+	worker = newFakeSession()
+	runPlaylistJobs(ctx, worker)
+	if videos := yt.playlists["PLA"]; len(videos) != 2 || videos[1] != "kJQP7kiw5Fk" || len(yt.playlists) != 1 {
+		t.Errorf("Got playlists %q, want the changed pick swapped in", yt.playlists)
+	}
+}
+
+func TestMusicPlaylistForOnePick(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	db.AddMonth(ctx, month{GuildID: "1", StartTime: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC), Days: []day{{Day: 1, Prompt: "birds"}}})
+	db.SaveSong(ctx, song{GuildID: "1", UserID: "100", Month: "Jan 2020", Day: 1, Song: "https://youtu.be/9bZkp7q19f0"})
+
+	// One person's pick for one day doesn't need YouTube at all
+	fake := newFakeSession()
+	handleInteraction(ctx, fake, slashCommand("musicplaylist", mineOption(true), intOption("day", 1)))
+	if len(fake.responseEdits) != 1 || fake.responseEdits[0] != "Your pick for day 1 of Jan 2020 was https://youtu.be/9bZkp7q19f0" {
+		t.Errorf("Got edits %q, want the pick", fake.responseEdits)
+	}
+
+	fake = newFakeSession()
+	handleInteraction(ctx, fake, slashCommand("musicplaylist", mineOption(true)))
+	if len(fake.responseEdits) != 1 || !strings.HasPrefix(fake.responseEdits[0], "I haven't been set up to make playlists") {
+		t.Errorf("Got edits %q, want a refusal without YouTube", fake.responseEdits)
+	}
+}
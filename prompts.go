@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/robfig/cron/v3"
+)
+
+// promptPostedSetting, followed by a guild ID, holds the last date that guild's prompt was claimed
+const promptPostedSetting = "promptPosted-"
+
+// promptThreadArchive is how long a picks thread stays open after its last post, in minutes
+const promptThreadArchive = 1440
+
+// promptAttempts is how many times a day's announcement is tried, promptRetryDelay apart
+const (
+	promptAttempts   = 3
+	promptRetryDelay = 5 * time.Minute
+)
+
+// scheduleAnnouncements announces the day's prompt at each guild's prompt time
+func scheduleAnnouncements(ctx context.Context, c *cron.Cron, s botSession) error {
+	for _, guildID := range conf.guildIDs() {
+		g := conf.guild(guildID)
+		if g.PromptChannel == "" {
+			continue
+		}
+		// Both were checked when the config was loaded
+		hour, minute, _ := timeOfDay(g.PromptTime)
+		loc, err := findTimezone(g.PromptTimezone)
+		if err != nil {
+			return err
+		}
+		guildID := guildID
+		spec := fmt.Sprintf("CRON_TZ=%v %d %d * * *", loc, minute, hour)
+		_, err = c.AddFunc(spec, func() {
+			for attempt := 1; ; attempt++ {
+				err := announcePrompt(ctx, s, guildID, time.Now().In(loc))
+				if err == nil {
+					return
+				}
+				log.Printf("Announcing the music prompt in guild %v: %v", guildID, err)
+				if attempt == promptAttempts {
+					notifyAdmins(s, guildID, "I couldn't announce today's music prompt: "+err.Error())
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(promptRetryDelay):
+				}
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("scheduling prompts for guild %v: %v", guildID, err)
+		}
+	}
+	return nil
+}
+
+// announcePrompt posts yesterday's picks and today's prompt, and opens a thread for today's
+func announcePrompt(ctx context.Context, s botSession, guildID string, now time.Time) error {
+	channelID := conf.guild(guildID).PromptChannel
+	today := now.Format("2006-01-02")
+	posted, err := db.Setting(ctx, promptPostedSetting+guildID)
+	if err != nil && err != errNotFound {
+		return fmt.Errorf("checking whether it's been announced: %v", err)
+	}
+	if string(posted) == today {
+		return nil
+	}
+
+	m, err := activeMonth(ctx, guildID, now)
+	if err == errNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("getting music month: %v", err)
+	}
+	monthName := m.StartTime.Format("Jan 2006")
+	// In the grace days, last month is still active but its prompts are over
+	if monthName != now.Format("Jan 2006") {
+		return nil
+	}
+	prompt, ok := monthPrompt(m, now.Day())
+	if !ok {
+		return nil
+	}
+	// Only whoever claims the day announces it
+	if err := db.SwapSetting(ctx, promptPostedSetting+guildID, posted, []byte(today)); err == errNotClaimed {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("claiming the announcement: %v", err)
+	}
+	// Until the prompt's out, a failure hands the day back to be tried again
+	release := func(err error) error {
+		if swapErr := db.SwapSetting(ctx, promptPostedSetting+guildID, []byte(today), posted); swapErr != nil {
+			log.Printf("Couldn't hand back guild %v's announcement: %v", guildID, swapErr)
+		}
+		return err
+	}
+
+	if yesterday, ok := monthPrompt(m, now.Day()-1); ok {
+		songs, err := db.Songs(ctx, guildID, monthName, "", now.Day()-1)
+		if err != nil {
+			return release(fmt.Errorf("getting yesterday's picks: %v", err))
+		}
+		if len(songs) > 0 {
+			_, err := s.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+				Content:         promptRecap(now.Day()-1, yesterday, songs),
+				AllowedMentions: &discordgo.MessageAllowedMentions{},
+				Flags:           discordgo.MessageFlagsSuppressEmbeds,
+			})
+			if err != nil {
+				return release(fmt.Errorf("posting yesterday's picks: %v", err))
+			}
+		}
+	}
+
+	msg, err := s.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Content:         "**Day " + strconv.Itoa(now.Day()) + "**: " + prompt + "\nPick a song with /music and talk about today's picks in the thread",
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	if err != nil {
+		return release(fmt.Errorf("posting the prompt: %v", err))
+	}
+	// The prompt's out, so a missing thread is only worth telling the admins about
+	if _, err := s.MessageThreadStart(channelID, msg.ID, threadName(now.Day(), prompt), promptThreadArchive); err != nil {
+		log.Printf("Starting guild %v's picks thread: %v", guildID, err)
+		notifyAdmins(s, guildID, "I announced today's music prompt but couldn't start its picks thread: "+err.Error())
+	}
+	return nil
+}
+
+// monthPrompt gets the prompt for a day of a music month
+func monthPrompt(m *month, day int) (string, bool) {
+	for _, d := range m.Days {
+		if d.Day == day {
+			return d.Prompt, true
+		}
+	}
+	return "", false
+}
+
+// threadName names a day's picks thread, within Discord's 100 characters
+func threadName(day int, prompt string) string {
+	name := []rune("Day " + strconv.Itoa(day) + ": " + prompt)
+	if len(name) > 100 {
+		name = append(name[:99], '…')
+	}
+	return string(name)
+}
+
+// promptRecap lists everyone's picks for a day
+func promptRecap(day int, prompt string, songs []song) string {
+	lines := make([]string, len(songs))
+	for n, picked := range songs {
+		lines[n] = "<@" + picked.UserID + ">: " + picked.Song
+	}
+	return limitLines("Yesterday's picks for day "+strconv.Itoa(day)+", "+prompt+":", lines, andMore)
+}
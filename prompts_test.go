@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+func TestAnnouncePrompt(t *testing.T) {
+	useTestBot(t)
+	conf.Guilds = map[string]guildConfig{"1": {PromptChannel: "500"}}
+	ctx := context.Background()
+	start := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	db.AddMonth(ctx, month{GuildID: "1", StartTime: start, Days: []day{{Day: 1, Prompt: "birds"}, {Day: 2, Prompt: "bears"}}})
+	db.SaveSong(ctx, song{GuildID: "1", UserID: "100", Month: "Mar 2024", Day: 1, Song: "https://youtu.be/banjo"})
+	db.SaveSong(ctx, song{GuildID: "1", UserID: "101", Month: "Mar 2024", Day: 1, Song: "Jiggy"})
+	db.SaveSong(ctx, song{GuildID: "1", UserID: "102", Month: "Mar 2024", Day: 2, Song: "too early"})
+
+	fake := newFakeSession()
+	now := start.AddDate(0, 0, 1).Add(9 * time.Hour)
+	if err := announcePrompt(ctx, fake, "1", now); err != nil {
+		t.Fatalf("Couldn't announce: %v", err)
+	}
+	got := fake.messages["500"]
+	if len(got) != 2 {
+		t.Fatalf("Got messages %q, want a recap and the prompt", got)
+	}
+	for _, want := range []string{"day 1, birds", "<@100>: https://youtu.be/banjo", "<@101>: Jiggy"} {
+		if !strings.Contains(got[0], want) {
+			t.Errorf("Recap %q doesn't mention %q", got[0], want)
+		}
+	}
+	if strings.Contains(got[0], "too early") {
+		t.Errorf("Recap %q has today's picks", got[0])
+	}
+	if !strings.HasPrefix(got[1], "**Day 2**: bears") {
+		t.Errorf("Got prompt %q, want day 2's", got[1])
+	}
+	for _, sent := range fake.sent {
+		if sent.AllowedMentions == nil || len(sent.AllowedMentions.Parse)+len(sent.AllowedMentions.Users) != 0 {
+			t.Errorf("Message %q is allowed to ping", sent.Content)
+		}
+	}
+	promptID := fake.history["500"][0].ID
+	if name := fake.threads[promptID]; name != "Day 2: bears" {
+		t.Errorf("Got threads %v, want one for day 2 on the prompt", fake.threads)
+	}
+
+	// A restart or another instance shouldn't announce it again
+	if err := announcePrompt(ctx, fake, "1", now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.messages["500"]) != 2 {
+		t.Errorf("Prompt was announced twice: %q", fake.messages["500"])
+	}
+}
+
+func TestAnnouncePromptRetriesAfterAFailedPost(t *testing.T) {
+	useTestBot(t)
+	conf.Guilds = map[string]guildConfig{"1": {PromptChannel: "500"}}
+	ctx := context.Background()
+	start := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	db.AddMonth(ctx, month{GuildID: "1", StartTime: start, Days: []day{{Day: 1, Prompt: "birds"}}})
+
+	fake := newFakeSession()
+	fake.failing = map[string]error{"500": errors.New("HTTP 503 Service Unavailable")}
+	now := start.Add(9 * time.Hour)
+	if err := announcePrompt(ctx, fake, "1", now); err == nil {
+		t.Fatal("Announcing into a failing channel worked")
+	}
+
+	delete(fake.failing, "500")
+	if err := announcePrompt(ctx, fake, "1", now.Add(promptRetryDelay)); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.messages["500"]; len(got) != 1 || !strings.HasPrefix(got[0], "**Day 1**: birds") {
+		t.Errorf("Got messages %q, want the prompt on the retry", got)
+	}
+}
+
+func TestAnnouncePromptWithoutPrompt(t *testing.T) {
+	useTestBot(t)
+	conf.Guilds = map[string]guildConfig{"1": {PromptChannel: "500"}}
+	ctx := context.Background()
+	start := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	db.AddMonth(ctx, month{GuildID: "1", StartTime: start, Days: []day{{Day: 1, Prompt: "birds"}}})
+
+	fake := newFakeSession()
+	for _, now := range []time.Time{
+		// No prompt for the day
+		start.AddDate(0, 0, 4),
+		// No month at all
+		start.AddDate(0, 3, 0),
+	} {
+		if err := announcePrompt(ctx, fake, "1", now); err != nil {
+			t.Errorf("Announcing on %v: %v", now, err)
+		}
+	}
+	if len(fake.messages) != 0 {
+		t.Errorf("Got messages %q, want none", fake.messages)
+	}
+}
+
+func TestScheduleAnnouncements(t *testing.T) {
+	useTestBot(t)
+	conf.Guilds = map[string]guildConfig{
+		"1": {PromptChannel: "500", PromptTime: "20:30", PromptTimezone: "America/New_York"},
+		"2": {},
+	}
+	c := cron.New()
+	if err := scheduleAnnouncements(context.Background(), c, newFakeSession()); err != nil {
+		t.Fatal(err)
+	}
+	entries := c.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("Got %d jobs, want one for the guild with a prompt channel", len(entries))
+	}
+	ny, _ := time.LoadLocation("America/New_York")
+	from := time.Date(2024, time.March, 1, 12, 0, 0, 0, ny)
+	if next := entries[0].Schedule.Next(from); !next.Equal(time.Date(2024, time.March, 1, 20, 30, 0, 0, ny)) {
+		t.Errorf("Job next runs at %v, want 20:30 New York time", next)
+	}
+}
+
+func TestPromptRecapIsCutShort(t *testing.T) {
+	var songs []song
+	for n := 0; n < 100; n++ {
+		songs = append(songs, song{UserID: strconv.Itoa(100 + n), Song: "https://youtu.be/" + strings.Repeat("x", 11)})
+	}
+	got := promptRecap(1, "birds", songs)
+	if len(got) > 2000 || !strings.HasSuffix(got, " more") {
+		t.Errorf("Recap is %d long, ending %q", len(got), got[len(got)-20:])
+	}
+	shown := strings.Count(got, "\n") - 1
+	if want := "...and " + strconv.Itoa(len(songs)-shown) + " more"; !strings.HasSuffix(got, want) {
+		t.Errorf("Recap ends %q, want %q", got[len(got)-20:], want)
+	}
+}
@@ -0,0 +1,1188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/robfig/cron/v3"
+)
+
+// minOne is for options that need to be at least 1
+var minOne = 1.0
+
+var reminderCommand = &command{
+	Name:        "reminder",
+	Description: "Set a reminder for yourself",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "reminder",
+			Description: "Thing to remind you of",
+			Required:    true,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "when",
+			Description: "When should I remind you? eg in 2 hours, tomorrow 9am, next friday at 18:00, 2026-12-25 10:00",
+			Required:    true,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "repeat",
+			Description: "Keep reminding you after the first time",
+			Choices: []*discordgo.ApplicationCommandOptionChoice{
+				{Name: "Every day", Value: "daily"},
+				{Name: "Every week, on the days you pick", Value: "weekly"},
+				{Name: "Every few hours", Value: "hours"},
+				{Name: "On a cron schedule", Value: "cron"},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "days",
+			Description: "For weekly reminders, which days, like mon,wed,fri or weekdays (defaults to the first one's day)",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionInteger,
+			Name:        "hours",
+			Description: "For reminders every few hours, how many hours apart",
+			MinValue:    &minOne,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "cron",
+			Description: "For cron reminders, a cron expression like 0 9 * * 1-5, in your time zone",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "until",
+			Description: "Stop repeating after this, like 2026-12-25 18:00 or in 3 months",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionInteger,
+			Name:        "times",
+			Description: "Stop repeating after reminding you this many times",
+			MinValue:    &minOne,
+		},
+		{
+			Type:         discordgo.ApplicationCommandOptionChannel,
+			Name:         "channel",
+			Description:  "Post it in this channel instead of DMing you (announcers only)",
+			ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText, discordgo.ChannelTypeGuildNews},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionMentionable,
+			Name:        "ping",
+			Description: "Someone or a role to ping with it, posting it here if there's no channel (announcers only)",
+		},
+	},
+	Handler: reminderHandler,
+}
+
+// announcementRefusal says why someone can't post or ping what they asked for, or is empty if they can
+func announcementRefusal(ctx context.Context, i *discordgo.InteractionCreate, role *discordgo.Role) (string, error) {
+	allowed, err := hasCapability(ctx, i.GuildID, i.Member, capAnnouncer)
+	if err != nil {
+		return "", err
+	}
+	if !allowed {
+		return "You need the announcer permission to post reminders in channels or ping people", nil
+	}
+	if role != nil && (role.ID == i.GuildID || !role.Mentionable) && i.Member.Permissions&discordgo.PermissionMentionEveryone == 0 && !isAdmin(i.GuildID, i.Member) {
+		return "You can't ping " + role.Name + " yourself, so I can't do it for you", nil
+	}
+	return "", nil
+}
+
+// announcement is what's posted for a reminder with a channel, and who it's allowed to ping
+func announcement(r reminder) (string, *discordgo.MessageAllowedMentions) {
+	switch {
+	case r.PingRoleID == r.GuildID && r.PingRoleID != "":
+		return "@everyone " + r.Reminder, &discordgo.MessageAllowedMentions{Parse: []discordgo.AllowedMentionType{discordgo.AllowedMentionTypeEveryone}}
+	case r.PingRoleID != "":
+		return "<@&" + r.PingRoleID + "> " + r.Reminder, &discordgo.MessageAllowedMentions{Roles: []string{r.PingRoleID}}
+	case r.PingUserID != "":
+		return "<@" + r.PingUserID + "> " + r.Reminder, &discordgo.MessageAllowedMentions{Users: []string{r.PingUserID}}
+	}
+	return r.Reminder, &discordgo.MessageAllowedMentions{}
+}
+
+func reminderHandler(ctx context.Context, s botSession, i *discordgo.InteractionCreate, opts commandOptions) {
+	if db == nil {
+		// We're not connected to GCP, don't let them do this
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "I haven't been set up to allow reminders, please moan at whoever set me up",
+			},
+		})
+		return
+	}
+	refuse := func(content string) {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags:   64,
+				Content: content,
+			},
+		})
+	}
+
+	now := time.Now().In(userLocation(ctx, s, i))
+	when, err := parseWhen(opts.String("when"), now)
+	if err != nil {
+		refuse("I couldn't work out when that is: " + err.Error() + ". " + whenExamples)
+		return
+	}
+	r := reminder{
+		GuildID:   i.GuildID,
+		UserID:    i.Member.User.ID,
+		ChannelID: i.ChannelID,
+		Reminder:  opts.String("reminder"),
+		Date:      when,
+	}
+	var repeats string
+	r.Repeat, repeats, err = repeatSchedule(opts, when)
+	if err != nil {
+		refuse("That doesn't repeat properly: " + err.Error())
+		return
+	}
+	if opts.Has("until") {
+		r.Until, err = parseWhen(opts.String("until"), now)
+		if err != nil {
+			refuse("I couldn't work out when to stop: " + err.Error() + ". " + whenExamples)
+			return
+		}
+		if r.Until.Before(when) {
+			refuse("That stops repeating before the first reminder")
+			return
+		}
+		repeats += ", until " + r.Until.Format(reminderTimeFormat)
+	}
+	if opts.Has("times") {
+		r.Times = opts.Int("times")
+		repeats += ", " + strconv.Itoa(r.Times) + " times in all"
+	}
+	if opts.Has("channel") || opts.Has("ping") {
+		user, role := opts.Mentionable("ping")
+		refusal, err := announcementRefusal(ctx, i, role)
+		if err != nil {
+			refuse("Something went wrong at my end so I couldn't check you're allowed to do that")
+			report(s, i, fmt.Errorf("checking permissions: %v", err))
+			return
+		}
+		if refusal != "" {
+			refuse(refusal)
+			return
+		}
+		r.PostChannelID = i.ChannelID
+		if opts.Has("channel") {
+			r.PostChannelID = opts.Channel("channel").ID
+		}
+		perms, err := s.UserChannelPermissions(i.Member.User.ID, r.PostChannelID)
+		if err != nil {
+			refuse("Something went wrong at my end so I couldn't check you're allowed to do that")
+			report(s, i, fmt.Errorf("checking channel permissions: %v", err))
+			return
+		}
+		if perms&discordgo.PermissionViewChannel == 0 || perms&discordgo.PermissionSendMessages == 0 {
+			refuse("You can't post in <#" + r.PostChannelID + "> yourself, so I can't do it for you")
+			return
+		}
+		if role != nil {
+			r.PingRoleID = role.ID
+		}
+		if user != nil {
+			r.PingUserID = user.ID
+		}
+	}
+
+	r.ID, err = db.AddReminder(ctx, r)
+	if err != nil {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "Something went wrong at my end so I didn't save your reminder",
+			},
+		})
+		report(s, i, fmt.Errorf("saving reminder: %v", err))
+		return
+	}
+	reminderTimers.Schedule(r)
+
+	content := "Okay, I'll remind you of " + r.Reminder
+	if r.PostChannelID != "" {
+		ping, _ := announcement(reminder{GuildID: r.GuildID, PingRoleID: r.PingRoleID, PingUserID: r.PingUserID})
+		content = "Okay, I'll post " + r.Reminder + " in <#" + r.PostChannelID + ">"
+		if ping = strings.TrimSpace(ping); ping != "" {
+			content += " pinging " + ping
+		}
+	}
+	content += " on " + when.Format(reminderTimeFormat) + " (<t:" + strconv.FormatInt(when.Unix(), 10) + ":R>)"
+	if r.Repeat != "" {
+		content += ", then " + repeats
+	}
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			// Whatever the reminder says, saying it back shouldn't ping anyone
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		},
+	})
+}
+
+// repeatSchedule builds the cron spec and a description for repeating after when, both empty for one-offs
+func repeatSchedule(opts commandOptions, when time.Time) (spec, description string, err error) {
+	repeat := opts.String("repeat")
+	for _, o := range []struct{ option, goesWith string }{{"days", "weekly"}, {"hours", "hours"}, {"cron", "cron"}} {
+		if opts.Has(o.option) && repeat != o.goesWith {
+			return "", "", fmt.Errorf("%v only goes with repeating %v", o.option, o.goesWith)
+		}
+	}
+	if repeat == "" {
+		if opts.Has("until") || opts.Has("times") {
+			return "", "", errors.New("until and times only go with repeat")
+		}
+		return "", "", nil
+	}
+
+	zone := "CRON_TZ=" + when.Location().String() + " "
+	at := strconv.Itoa(when.Minute()) + " " + strconv.Itoa(when.Hour())
+	switch repeat {
+	case "daily":
+		return zone + at + " * * *", "every day at " + when.Format("15:04"), nil
+	case "weekly":
+		days := []time.Weekday{when.Weekday()}
+		if opts.Has("days") {
+			days, err = parseWeekdays(opts.String("days"))
+			if err != nil {
+				return "", "", err
+			}
+		}
+		numbers := make([]string, len(days))
+		names := make([]string, len(days))
+		for n, d := range days {
+			numbers[n] = strconv.Itoa(int(d))
+			names[n] = d.String()
+		}
+		return zone + at + " * * " + strings.Join(numbers, ","), "every " + joinAnd(names) + " at " + when.Format("15:04"), nil
+	case "hours":
+		if !opts.Has("hours") {
+			return "", "", errors.New("say how many hours apart with the hours option")
+		}
+		hours := opts.Int("hours")
+		if hours == 1 {
+			return "@every 1h", "every hour", nil
+		}
+		return "@every " + strconv.Itoa(hours) + "h", "every " + strconv.Itoa(hours) + " hours", nil
+	case "cron":
+		if !opts.Has("cron") {
+			return "", "", errors.New("give a cron expression with the cron option")
+		}
+		expr := strings.TrimSpace(opts.String("cron"))
+		spec = expr
+		if !strings.HasPrefix(expr, "CRON_TZ=") && !strings.HasPrefix(expr, "TZ=") {
+			spec = zone + expr
+		}
+		if _, err := cron.ParseStandard(spec); err != nil {
+			return "", "", fmt.Errorf("%q isn't a cron expression I understand: %v", expr, err)
+		}
+		return spec, "on the schedule " + expr, nil
+	}
+	return "", "", fmt.Errorf("I don't know how to repeat %q", repeat)
+}
+
+// retimeSchedule moves a repeat spec to when's time of day, or returns false if it can't
+func retimeSchedule(spec string, when time.Time) (string, bool) {
+	if spec == "" || strings.HasPrefix(spec, "@every ") {
+		return spec, true
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 6 || !strings.HasPrefix(fields[0], "CRON_TZ=") || fields[3] != "*" || fields[4] != "*" {
+		return "", false
+	}
+	for _, f := range fields[1:3] {
+		if _, err := strconv.Atoi(f); err != nil {
+			return "", false
+		}
+	}
+	return "CRON_TZ=" + when.Location().String() + " " + strconv.Itoa(when.Minute()) + " " + strconv.Itoa(when.Hour()) + " * * " + fields[5], true
+}
+
+// parseWeekdays reads days like "mon,wed,fri" or "weekdays", in order with no repeats
+func parseWeekdays(text string) ([]time.Weekday, error) {
+	picked := map[time.Weekday]bool{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return r == ',' || r == ' ' || r == '/' }) {
+		switch word {
+		case "weekdays":
+			for d := time.Monday; d <= time.Friday; d++ {
+				picked[d] = true
+			}
+		case "weekends":
+			picked[time.Saturday], picked[time.Sunday] = true, true
+		case "and":
+		default:
+			d, ok := weekdays[word]
+			if !ok {
+				return nil, fmt.Errorf("%q isn't a day of the week", word)
+			}
+			picked[d] = true
+		}
+	}
+	if len(picked) == 0 {
+		return nil, errors.New("pick at least one day")
+	}
+	var days []time.Weekday
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if picked[d] {
+			days = append(days, d)
+		}
+	}
+	return days, nil
+}
+
+// joinAnd lists things the way you'd say them: "a", "a and b", "a, b and c"
+func joinAnd(items []string) string {
+	if len(items) < 2 {
+		return strings.Join(items, "")
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
+
+// nextReminder works out when a reminder goes off after now, or returns false if it's finished
+func nextReminder(r reminder, now time.Time) (time.Time, bool) {
+	counted := r.Sent
+	if !r.Snoozed {
+		counted++
+	}
+	if r.Repeat == "" || (r.Times > 0 && counted >= r.Times) {
+		return time.Time{}, false
+	}
+	schedule, err := cron.ParseStandard(r.Repeat)
+	if err != nil {
+		log.Printf("Reminder %v has a bad schedule %q, so it won't repeat: %v", r.ID, r.Repeat, err)
+		return time.Time{}, false
+	}
+	next := schedule.Next(r.Date)
+	for !next.IsZero() && !next.After(now) {
+		next = schedule.Next(next)
+	}
+	// Next gives a zero time for schedules that never come round, like 30 February
+	if next.IsZero() || (!r.Until.IsZero() && next.After(r.Until)) {
+		return time.Time{}, false
+	}
+	return next, true
+}
+
+// snoozeWindow is how long a delivered reminder is kept so it can be snoozed from its message
+const snoozeWindow = 24 * time.Hour
+
+// reminderToken identifies one delivery of a reminder, so stale buttons can't move it
+func reminderToken(r reminder) string {
+	return strconv.FormatInt(r.Date.Unix(), 10)
+}
+
+// reminderButtons go on a delivered reminder, which has already been moved on to its next time
+func reminderButtons(r reminder) ([]discordgo.MessageComponent, error) {
+	var buttons []discordgo.MessageComponent
+	for _, snooze := range []struct{ label, until string }{{"Snooze 10m", "10m"}, {"Snooze 1h", "1h"}, {"Snooze until tomorrow", "tomorrow"}} {
+		id, err := customID("reminder-snooze", r.ID, reminderToken(r), snooze.until)
+		if err != nil {
+			return nil, err
+		}
+		buttons = append(buttons, discordgo.Button{Label: snooze.label, Style: discordgo.SecondaryButton, CustomID: id})
+	}
+	done, err := customID("reminder-done", r.ID, reminderToken(r))
+	if err != nil {
+		return nil, err
+	}
+	buttons = append(buttons, discordgo.Button{Label: "Done", Style: discordgo.SuccessButton, CustomID: done})
+	return []discordgo.MessageComponent{discordgo.ActionsRow{Components: buttons}}, nil
+}
+
+// checkReminders delivers anything due and queues everything due before the next check
+func checkReminders(ctx context.Context, s botSession) {
+	now := time.Now()
+	reminders, err := db.DueReminders(ctx, now.Add(2*reminderResync))
+	if err != nil {
+		log.Printf("Something went wrong getting reminders: %v", err)
+		return
+	}
+	for _, r := range reminders {
+		processReminder(ctx, s, r, now)
+	}
+}
+
+// processReminder delivers, clears up or queues a reminder as it needs
+func processReminder(ctx context.Context, s botSession, r reminder, now time.Time) {
+	if r.Delivered {
+		if now.Before(r.Date.Add(snoozeWindow)) {
+			reminderTimers.Schedule(r)
+			return
+		}
+		reminderTimers.Cancel(r.ID)
+		if err := db.DeleteReminder(ctx, r.ID); err != nil {
+			log.Printf("Couldn't clear up delivered reminder %v: %v", r.ID, err)
+		}
+		return
+	}
+	if r.Dead {
+		return
+	}
+	if r.dueAt().After(now) {
+		reminderTimers.Schedule(r)
+		return
+	}
+
+	// Claim it first so no other instance delivers it too
+	claimed, err := db.ClaimReminder(ctx, r.ID, instanceID, now, now.Add(reminderLease))
+	if err == errNotClaimed {
+		return
+	}
+	if err != nil {
+		log.Printf("Couldn't claim reminder %v: %v", r.ID, err)
+		return
+	}
+	r = *claimed
+
+	next, again := nextReminder(r, now)
+	message := "Hi there! You asked me to remind you about " + r.Reminder + " - this is that reminder!"
+	moved := r
+	if again {
+		message += " I'll remind you again <t:" + strconv.FormatInt(next.Unix(), 10) + ":R>"
+		moved.Date = next
+	} else {
+		moved.Delivered = true
+	}
+	if r.AboutMessageID != "" {
+		message += "\n" + quoteText(r.Quote) + aboutLink(r)
+	}
+	if !moved.Snoozed {
+		moved.Sent++
+	}
+	moved.Snoozed = false
+	moved.Attempts, moved.RetryAt, moved.Failure = 0, time.Time{}, ""
+
+	sent, err := sendReminder(s, r, moved, message)
+	if err != nil {
+		// Leave it where it was to be tried again
+		log.Printf("Error trying to remind someone about %v: %v", r.ID, err)
+		moved = failedReminder(s, r, err, now)
+	} else {
+		moved.MessageID = sent
+	}
+
+	err = db.CompleteReminder(ctx, moved, instanceID)
+	if err == errLeaseLost {
+		// Whoever took over will find the message and not send it again
+		log.Printf("Took too long delivering reminder %v, so it's been claimed by someone else", r.ID)
+		return
+	}
+	if err != nil {
+		log.Printf("Couldn't move reminder %v on after delivering it: %v", r.ID, err)
+		return
+	}
+	reminderTimers.Schedule(moved)
+}
+
+const (
+	// reminderDMAttempts are tried by DM before falling back to the channel, and reminderAttempts in all
+	reminderDMAttempts = 4
+	reminderAttempts   = 6
+	// reminderRetryDelay is the wait before the first retry, doubling after that
+	reminderRetryDelay = time.Minute
+)
+
+// sendReminder delivers a claimed reminder, reusing a message an interrupted try already sent
+func sendReminder(s botSession, claimed, r reminder, message string) (string, error) {
+	buttons, err := reminderButtons(r)
+	if err != nil {
+		return "", err
+	}
+	send := &discordgo.MessageSend{Content: message, Components: buttons}
+	var channelID string
+	if r.PostChannelID != "" {
+		channelID = r.PostChannelID
+		send = &discordgo.MessageSend{}
+		send.Content, send.AllowedMentions = announcement(r)
+	} else if claimed.Attempts < reminderDMAttempts {
+		channel, err := s.UserChannelCreate(r.UserID)
+		if err != nil {
+			return "", fmt.Errorf("opening DMs: %v", err)
+		}
+		channelID = channel.ID
+	} else {
+		if r.ChannelID == "" {
+			return "", errors.New("DMs didn't work and there's no channel to fall back to")
+		}
+		channelID = r.ChannelID
+		send.Content = "<@" + r.UserID + "> " + message
+		send.AllowedMentions = &discordgo.MessageAllowedMentions{Users: []string{r.UserID}}
+	}
+
+	// A claim left over means whoever had it stopped partway, maybe after sending it
+	if claimed.ClaimedBy != "" && claimed.ClaimedBy != instanceID {
+		if id, ok := findDelivery(s, channelID, r, send, claimed.dueAt()); ok {
+			return id, nil
+		}
+	}
+	msg, err := s.ChannelMessageSendComplex(channelID, send)
+	if err != nil {
+		return "", err
+	}
+	return msg.ID, nil
+}
+
+// failedReminder records a failed delivery, scheduling a retry or giving up and telling the admins
+func failedReminder(s botSession, r reminder, err error, now time.Time) reminder {
+	r.Attempts++
+	r.Failure = err.Error()
+	var rest *discordgo.RESTError
+	if r.Attempts < reminderDMAttempts && errors.As(err, &rest) && rest.Message != nil && rest.Message.Code == discordgo.ErrCodeCannotSendMessagesToThisUser {
+		// They've closed their DMs, which trying again won't fix
+		r.Attempts = reminderDMAttempts
+		r.RetryAt = now
+		return r
+	}
+	if r.Attempts >= reminderAttempts {
+		r.Dead = true
+		notifyAdmins(s, r.GuildID, "I couldn't deliver a reminder for <@"+r.UserID+"> after "+strconv.Itoa(r.Attempts)+" tries, so I've given up on it. The last problem was: "+r.Failure+". See /reminders undelivered")
+		return r
+	}
+	r.RetryAt = now.Add(reminderRetryDelay << uint(r.Attempts-1))
+	return r
+}
+
+// findDelivery looks for a message already sent for this delivery of a reminder
+func findDelivery(s botSession, channelID string, r reminder, send *discordgo.MessageSend, since time.Time) (string, bool) {
+	msgs, err := s.ChannelMessages(channelID, 20, "", "", "")
+	if err != nil {
+		// Better to send it twice than not at all
+		log.Printf("Couldn't check whether reminder %v was already sent: %v", r.ID, err)
+		return "", false
+	}
+	// Without a custom ID the reminder can't have been sent with buttons
+	done, _ := customID("reminder-done", r.ID, reminderToken(r))
+	for _, msg := range msgs {
+		if len(send.Components) == 0 && msg.Content == send.Content && !msg.Timestamp.Before(since) {
+			return msg.ID, true
+		}
+		for _, row := range msg.Components {
+			// Components read back from Discord are pointers, ones built here are values
+			var buttons []discordgo.MessageComponent
+			switch row := row.(type) {
+			case *discordgo.ActionsRow:
+				buttons = row.Components
+			case discordgo.ActionsRow:
+				buttons = row.Components
+			}
+			for _, button := range buttons {
+				switch button := button.(type) {
+				case *discordgo.Button:
+					if button.CustomID == done {
+						return msg.ID, true
+					}
+				case discordgo.Button:
+					if button.CustomID == done {
+						return msg.ID, true
+					}
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// deliveredReminder gets the reminder a button is for, or answers the click and returns nil
+func deliveredReminder(ctx context.Context, s botSession, i *discordgo.InteractionCreate, id, token string) *reminder {
+	refuse := func(content string) {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags:   64,
+				Content: content,
+			},
+		})
+	}
+	if db == nil {
+		refuse("I haven't been set up to allow reminders, please moan at whoever set me up")
+		return nil
+	}
+	r, err := db.Reminder(ctx, id)
+	if err == errNotFound {
+		refuse("That reminder's been cleared up, so it's too late to do anything with it")
+		return nil
+	}
+	if err != nil {
+		refuse("Something went wrong at my end, sorry")
+		report(s, i, fmt.Errorf("getting reminder %v: %v", id, err))
+		return nil
+	}
+	if user := interactionUser(i); user == nil || user.ID != r.UserID {
+		refuse("That isn't your reminder")
+		return nil
+	}
+	if reminderToken(*r) != token {
+		refuse("That reminder's been moved since, so use the newest message about it")
+		return nil
+	}
+	return r
+}
+
+// closeReminderMessage takes the buttons off a delivered reminder, saying what happened to it
+func closeReminderMessage(s botSession, i *discordgo.InteractionCreate, outcome string) {
+	content := outcome
+	if i.Message != nil {
+		content = i.Message.Content + "\n\n" + outcome
+	}
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Components: []discordgo.MessageComponent{},
+		},
+	})
+}
+
+// snoozeReminder pushes a delivered reminder back. The args are its ID, token and when
+func snoozeReminder(ctx context.Context, s botSession, i *discordgo.InteractionCreate, args []string) {
+	r := deliveredReminder(ctx, s, i, args[0], args[1])
+	if r == nil {
+		return
+	}
+	when, err := parseWhen(args[2], time.Now().In(userLocation(ctx, s, i)))
+	if err != nil {
+		report(s, i, fmt.Errorf("snoozing until %q: %v", args[2], err))
+		return
+	}
+	r.Date = when
+	r.Snoozed = true
+	r.Delivered = false
+	if err := db.ChangeReminder(ctx, *r, time.Now()); err == errClaimed || err == errChanged {
+		respondQuietly(s, i, reminderBusy)
+		return
+	} else if err != nil {
+		report(s, i, fmt.Errorf("snoozing reminder: %v", err))
+		return
+	}
+	reminderTimers.Schedule(*r)
+	closeReminderMessage(s, i, "Snoozed until "+when.Format(reminderTimeFormat)+" (<t:"+strconv.FormatInt(when.Unix(), 10)+":R>)")
+}
+
+// finishReminder closes a delivered reminder. The args are its ID and token
+func finishReminder(ctx context.Context, s botSession, i *discordgo.InteractionCreate, args []string) {
+	r := deliveredReminder(ctx, s, i, args[0], args[1])
+	if r == nil {
+		return
+	}
+	if r.Delivered {
+		if err := db.CancelReminder(ctx, r.ID, time.Now()); err == errClaimed {
+			respondQuietly(s, i, reminderBusy)
+			return
+		} else if err != nil {
+			report(s, i, fmt.Errorf("deleting reminder: %v", err))
+			return
+		}
+		reminderTimers.Cancel(r.ID)
+	}
+	closeReminderMessage(s, i, "Done")
+}
+
+// reminderBusy is the answer to changing a reminder while it's being delivered
+const reminderBusy = "That reminder's going off right now, try again in a moment"
+
+// remindersPageSize is how many reminders /reminders list shows at once
+const remindersPageSize = 10
+
+// shortID is enough of a reminder's ID to pick it out
+func shortID(id string) string {
+	if len(id) > 6 {
+		return id[:6]
+	}
+	return id
+}
+
+// pendingReminders gets someone's reminders that haven't finished yet
+func pendingReminders(ctx context.Context, guildID, userID string) ([]reminder, error) {
+	all, err := db.UserReminders(ctx, guildID, userID)
+	if err != nil {
+		return nil, err
+	}
+	var pending []reminder
+	for _, r := range all {
+		if !r.Delivered {
+			pending = append(pending, r)
+		}
+	}
+	return pending, nil
+}
+
+// findReminder gets one of someone's pending reminders by its ID or short ID
+func findReminder(ctx context.Context, guildID, userID, id string) (*reminder, error) {
+	pending, err := pendingReminders(ctx, guildID, userID)
+	if err != nil {
+		return nil, err
+	}
+	var found *reminder
+	for n, r := range pending {
+		if r.ID == id {
+			return &pending[n], nil
+		}
+		if strings.HasPrefix(r.ID, id) {
+			if found != nil {
+				return nil, errNotFound
+			}
+			found = &pending[n]
+		}
+	}
+	if found == nil {
+		return nil, errNotFound
+	}
+	return found, nil
+}
+
+func describeReminder(r reminder) string {
+	line := "`" + shortID(r.ID) + "` <t:" + strconv.FormatInt(r.Date.Unix(), 10) + ":f> - " + r.Reminder
+	if r.Repeat != "" {
+		line += " (repeats)"
+	}
+	if r.PostChannelID != "" {
+		line += " in <#" + r.PostChannelID + ">"
+	}
+	if r.AboutMessageID != "" {
+		line += " " + aboutLink(r)
+	}
+	if r.Dead {
+		line += " (couldn't be delivered, edit it to try again)"
+	}
+	return line
+}
+
+// remindersPage shows one page of someone's reminders, with buttons to move between pages
+func remindersPage(ctx context.Context, guildID, userID string, page int) (*discordgo.InteractionResponseData, error) {
+	pending, err := pendingReminders(ctx, guildID, userID)
+	if err != nil {
+		return nil, err
+	}
+	data := &discordgo.InteractionResponseData{
+		Flags:      64,
+		Components: []discordgo.MessageComponent{},
+	}
+	if len(pending) == 0 {
+		data.Content = "You haven't got any reminders set up"
+		return data, nil
+	}
+	pages := (len(pending) + remindersPageSize - 1) / remindersPageSize
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+	var content strings.Builder
+	content.WriteString("Your reminders")
+	if pages > 1 {
+		content.WriteString(" (page " + strconv.Itoa(page+1) + " of " + strconv.Itoa(pages) + ")")
+	}
+	content.WriteString(":\n")
+	end := (page + 1) * remindersPageSize
+	if end > len(pending) {
+		end = len(pending)
+	}
+	for _, r := range pending[page*remindersPageSize : end] {
+		content.WriteString(describeReminder(r) + "\n")
+	}
+	data.Content = content.String()
+	if pages > 1 {
+		previous, err := customID("reminders-page", strconv.Itoa(page-1))
+		if err != nil {
+			return nil, err
+		}
+		next, err := customID("reminders-page", strconv.Itoa(page+1))
+		if err != nil {
+			return nil, err
+		}
+		data.Components = []discordgo.MessageComponent{
+			discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+				discordgo.Button{Label: "Previous", Style: discordgo.SecondaryButton, CustomID: previous, Disabled: page == 0},
+				discordgo.Button{Label: "Next", Style: discordgo.SecondaryButton, CustomID: next, Disabled: page == pages-1},
+			}},
+		}
+	}
+	return data, nil
+}
+
+// turnRemindersPage moves /reminders list to another page. The arg is the page number
+func turnRemindersPage(ctx context.Context, s botSession, i *discordgo.InteractionCreate, args []string) {
+	page, err := strconv.Atoi(args[0])
+	if err != nil {
+		report(s, i, fmt.Errorf("bad page %q: %v", args[0], err))
+		return
+	}
+	data, err := remindersPage(ctx, i.GuildID, interactionUser(i).ID, page)
+	if err != nil {
+		report(s, i, fmt.Errorf("listing reminders: %v", err))
+		return
+	}
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: data,
+	})
+}
+
+func whichReminderOption() *discordgo.ApplicationCommandOption {
+	return &discordgo.ApplicationCommandOption{
+		Type:         discordgo.ApplicationCommandOptionString,
+		Name:         "id",
+		Description:  "Which reminder, by its ID from /reminders list",
+		Required:     true,
+		Autocomplete: true,
+	}
+}
+
+var remindersCommand = &command{
+	Name:        "reminders",
+	Description: "See, cancel and change your reminders",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "list",
+			Description: "See the reminders you've set up here",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "cancel",
+			Description: "Stop a reminder",
+			Options:     []*discordgo.ApplicationCommandOption{whichReminderOption()},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "undelivered",
+			Description: "See reminders here that couldn't be delivered (admins only)",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "edit",
+			Description: "Change what a reminder says or when it goes off",
+			Options: []*discordgo.ApplicationCommandOption{
+				whichReminderOption(),
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "reminder",
+					Description: "What to remind you of instead",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "when",
+					Description: "When to remind you instead, like in 2 hours or tomorrow 9am",
+				},
+			},
+		},
+	},
+	Handler: remindersHandler,
+	Autocomplete: func(ctx context.Context, i *discordgo.InteractionCreate, opts commandOptions) []*discordgo.ApplicationCommandOptionChoice {
+		choices := []*discordgo.ApplicationCommandOptionChoice{}
+		if db == nil {
+			return choices
+		}
+		pending, err := pendingReminders(ctx, i.GuildID, i.Member.User.ID)
+		if err != nil {
+			log.Printf("Couldn't suggest reminders: %v", err)
+			return choices
+		}
+		typed := strings.ToLower(opts.String("id"))
+		for _, r := range pending {
+			name := shortID(r.ID) + ": " + r.Reminder
+			if !strings.HasPrefix(r.ID, opts.String("id")) && !strings.Contains(strings.ToLower(r.Reminder), typed) {
+				continue
+			}
+			// Choice names can only be 100 characters
+			if len(name) > 100 {
+				name = name[:97] + "..."
+			}
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: r.ID})
+		}
+		return choices
+	},
+}
+
+func remindersHandler(ctx context.Context, s botSession, i *discordgo.InteractionCreate, opts commandOptions) {
+	respond := func(content string) {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags:   64,
+				Content: content,
+			},
+		})
+	}
+	if db == nil {
+		respond("I haven't been set up to allow reminders, please moan at whoever set me up")
+		return
+	}
+	userID := i.Member.User.ID
+
+	if opts.Subcommand() == "list" {
+		data, err := remindersPage(ctx, i.GuildID, userID, 0)
+		if err != nil {
+			respond("Something went wrong at my end so I couldn't get your reminders")
+			report(s, i, fmt.Errorf("listing reminders: %v", err))
+			return
+		}
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: data,
+		})
+		return
+	}
+	if opts.Subcommand() == "undelivered" {
+		if !isAdmin(i.GuildID, i.Member) {
+			respond("Only admins can see everyone's undelivered reminders")
+			return
+		}
+		dead, err := db.UndeliveredReminders(ctx, i.GuildID)
+		if err != nil {
+			respond("Something went wrong at my end so I couldn't get the undelivered reminders")
+			report(s, i, fmt.Errorf("listing undelivered reminders: %v", err))
+			return
+		}
+		respond(undeliveredList(dead))
+		return
+	}
+
+	r, err := findReminder(ctx, i.GuildID, userID, strings.TrimSpace(opts.String("id")))
+	if err == errNotFound {
+		respond("I can't find a reminder of yours with the ID " + opts.String("id") + ". Check /reminders list")
+		return
+	}
+	if err != nil {
+		respond("Something went wrong at my end so I couldn't find your reminder")
+		report(s, i, fmt.Errorf("finding reminder: %v", err))
+		return
+	}
+
+	if opts.Subcommand() == "cancel" {
+		if err := db.CancelReminder(ctx, r.ID, time.Now()); err == errClaimed {
+			respond(reminderBusy)
+			return
+		} else if err != nil {
+			respond("Something went wrong at my end so I didn't cancel your reminder")
+			report(s, i, fmt.Errorf("deleting reminder: %v", err))
+			return
+		}
+		reminderTimers.Cancel(r.ID)
+		respond("Okay, I won't remind you of " + r.Reminder)
+		return
+	}
+
+	if !opts.Has("reminder") && !opts.Has("when") {
+		respond("Say what to change, with reminder or when")
+		return
+	}
+	if opts.Has("reminder") {
+		r.Reminder = opts.String("reminder")
+	}
+	if opts.Has("when") {
+		when, err := parseWhen(opts.String("when"), time.Now().In(userLocation(ctx, s, i)))
+		if err != nil {
+			respond("I couldn't work out when that is: " + err.Error() + ". " + whenExamples)
+			return
+		}
+		if !r.Until.IsZero() && r.Until.Before(when) {
+			respond("That's after the reminder stops repeating")
+			return
+		}
+		spec, ok := retimeSchedule(r.Repeat, when)
+		if !ok {
+			respond("That reminder repeats on a cron schedule, so cancel it and set it up again to change when it goes off")
+			return
+		}
+		r.Date, r.Repeat = when, spec
+	}
+	// Editing a reminder that couldn't be delivered gives it another go
+	r.Dead, r.Attempts, r.RetryAt, r.Failure = false, 0, time.Time{}, ""
+	if err := db.ChangeReminder(ctx, *r, time.Now()); err == errClaimed || err == errChanged {
+		respond(reminderBusy)
+		return
+	} else if err != nil {
+		respond("Something went wrong at my end so I didn't change your reminder")
+		report(s, i, fmt.Errorf("updating reminder: %v", err))
+		return
+	}
+	reminderTimers.Schedule(*r)
+	respond("Okay, I'll remind you of " + r.Reminder + " on " + r.Date.In(userLocation(ctx, s, i)).Format(reminderTimeFormat) + " (<t:" + strconv.FormatInt(r.Date.Unix(), 10) + ":R>)")
+}
+
+// undeliveredList describes dead reminders for admins, with why each one failed
+func undeliveredList(dead []reminder) string {
+	if len(dead) == 0 {
+		return "Every reminder here has been delivered fine"
+	}
+	lines := make([]string, len(dead))
+	for n, r := range dead {
+		lines[n] = "`" + shortID(r.ID) + "` <t:" + strconv.FormatInt(r.Date.Unix(), 10) + ":f> for <@" + r.UserID + "> - " + r.Reminder + ", tried " + strconv.Itoa(r.Attempts) + " times: " + r.Failure
+	}
+	return limitLines("These reminders couldn't be delivered:", lines, andMore)
+}
+
+// limitLines lists lines under heading, ending with more if they won't fit in a Discord message
+func limitLines(heading string, lines []string, more func(left int) string) string {
+	var content strings.Builder
+	content.WriteString(heading)
+	for n, line := range lines {
+		if content.Len()+1+len(line) > 1950 {
+			content.WriteString("\n" + more(len(lines)-n))
+			break
+		}
+		content.WriteString("\n" + line)
+	}
+	return content.String()
+}
+
+// andMore is the usual ending for limitLines, saying how many lines it left out
+func andMore(left int) string {
+	return "...and " + strconv.Itoa(left) + " more"
+}
+
+// quoteLength is how much of a message is quoted in a reminder about it
+const quoteLength = 300
+
+// remindAboutQuoteSetting, followed by the menu interaction's ID, holds what the message said
+// until the modal's submitted
+const remindAboutQuoteSetting = "remindAboutQuote-"
+
+// remindAboutCommand is on the message menu, asking when in a modal for remindAboutSubmit
+var remindAboutCommand = &command{
+	Type:    discordgo.MessageApplicationCommand,
+	Name:    "Remind me about this",
+	Handler: remindAboutHandler,
+}
+
+func remindAboutHandler(ctx context.Context, s botSession, i *discordgo.InteractionCreate, opts commandOptions) {
+	if db == nil {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags:   64,
+				Content: "I haven't been set up to allow reminders, please moan at whoever set me up",
+			},
+		})
+		return
+	}
+	data := i.ApplicationCommandData()
+	id, err := customID("remind-about", i.ChannelID, data.TargetID, i.ID)
+	if err != nil {
+		report(s, i, err)
+		return
+	}
+	// If it can't be kept, the link will have to do
+	if data.Resolved != nil && data.Resolved.Messages[data.TargetID] != nil {
+		if err := db.SaveSetting(ctx, remindAboutQuoteSetting+i.ID, []byte(data.Resolved.Messages[data.TargetID].Content)); err != nil {
+			log.Printf("Couldn't keep the message for remind me about this: %v", err)
+		}
+	}
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: id,
+			Title:    "Remind me about this",
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+					discordgo.TextInput{
+						CustomID:    "when",
+						Label:       "When?",
+						Style:       discordgo.TextInputShort,
+						Placeholder: "in 2 hours, tomorrow 9am, next friday at 18:00",
+						Required:    true,
+						MaxLength:   100,
+					},
+				}},
+				discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+					discordgo.TextInput{
+						CustomID:    "note",
+						Label:       "What about it?",
+						Style:       discordgo.TextInputParagraph,
+						Placeholder: "Leave this empty to just be sent the message",
+						MaxLength:   500,
+					},
+				}},
+			},
+		},
+	})
+}
+
+// remindAboutSubmit saves a reminder from the modal. The args are the channel, the message and
+// the menu interaction
+func remindAboutSubmit(ctx context.Context, s botSession, i *discordgo.InteractionCreate, args []string) {
+	respond := func(content string) {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags:   64,
+				Content: content,
+			},
+		})
+	}
+	if db == nil {
+		respond("I haven't been set up to allow reminders, please moan at whoever set me up")
+		return
+	}
+	quote, err := db.Setting(ctx, remindAboutQuoteSetting+args[2])
+	if err != nil && err != errNotFound {
+		log.Printf("Couldn't get the message for remind me about this: %v", err)
+	}
+	values := modalValues(i.ModalSubmitData())
+	loc := userLocation(ctx, s, i)
+	when, err := parseWhen(values["when"], time.Now().In(loc))
+	if err != nil {
+		respond("I couldn't work out when that is: " + err.Error() + ". " + whenExamples)
+		return
+	}
+
+	r := reminder{
+		GuildID:        i.GuildID,
+		UserID:         interactionUser(i).ID,
+		ChannelID:      args[0],
+		AboutMessageID: args[1],
+		Reminder:       strings.TrimSpace(values["note"]),
+		Quote:          string(quote),
+		Date:           when,
+	}
+	if r.Reminder == "" {
+		r.Reminder = "this message"
+	}
+
+	r.ID, err = db.AddReminder(ctx, r)
+	if err != nil {
+		respond("Something went wrong at my end so I didn't save your reminder")
+		report(s, i, fmt.Errorf("saving reminder: %v", err))
+		return
+	}
+	reminderTimers.Schedule(r)
+	db.SaveSetting(ctx, remindAboutQuoteSetting+args[2], nil)
+	respond("Okay, I'll remind you of " + r.Reminder + " on " + when.Format(reminderTimeFormat) + " (<t:" + strconv.FormatInt(when.Unix(), 10) + ":R>)")
+}
+
+// aboutLink links to the message a reminder is about
+func aboutLink(r reminder) string {
+	return "https://discord.com/channels/" + r.GuildID + "/" + r.ChannelID + "/" + r.AboutMessageID
+}
+
+// quoteText quotes the start of a message, a line at a time, ready to go before a link
+func quoteText(text string) string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return ""
+	}
+	if runes := []rune(text); len(runes) > quoteLength {
+		text = string(runes[:quoteLength]) + "..."
+	}
+	return "> " + strings.Replace(text, "\n", "\n> ", -1) + "\n"
+}
@@ -0,0 +1,654 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func intOption(name string, value int) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionInteger, Value: float64(value)}
+}
+
+func TestRepeatingReminderConfirmation(t *testing.T) {
+	tests := []struct {
+		options []*discordgo.ApplicationCommandInteractionDataOption
+		want    string
+		repeat  string
+	}{
+		{
+			[]*discordgo.ApplicationCommandInteractionDataOption{stringOption("repeat", "daily")},
+			"Okay, I'll remind you of feed the bird on Friday 25 December 2099 at 10:30 UTC (<t:4101877800:R>), then every day at 10:30",
+			"CRON_TZ=UTC 30 10 * * *",
+		},
+		{
+			[]*discordgo.ApplicationCommandInteractionDataOption{stringOption("repeat", "weekly"), stringOption("days", "fri, mon and wed")},
+			"Okay, I'll remind you of feed the bird on Friday 25 December 2099 at 10:30 UTC (<t:4101877800:R>), then every Monday, Wednesday and Friday at 10:30",
+			"CRON_TZ=UTC 30 10 * * 1,3,5",
+		},
+		{
+			[]*discordgo.ApplicationCommandInteractionDataOption{stringOption("repeat", "weekly"), intOption("times", 3)},
+			"Okay, I'll remind you of feed the bird on Friday 25 December 2099 at 10:30 UTC (<t:4101877800:R>), then every Friday at 10:30, 3 times in all",
+			"CRON_TZ=UTC 30 10 * * 5",
+		},
+		{
+			[]*discordgo.ApplicationCommandInteractionDataOption{stringOption("repeat", "hours"), intOption("hours", 6), stringOption("until", "2099-12-31")},
+			"Okay, I'll remind you of feed the bird on Friday 25 December 2099 at 10:30 UTC (<t:4101877800:R>), then every 6 hours, until Thursday 31 December 2099 at 09:00 UTC",
+			"@every 6h",
+		},
+		{
+			[]*discordgo.ApplicationCommandInteractionDataOption{stringOption("repeat", "cron"), stringOption("cron", "0 9 * * 1-5")},
+			"Okay, I'll remind you of feed the bird on Friday 25 December 2099 at 10:30 UTC (<t:4101877800:R>), then on the schedule 0 9 * * 1-5",
+			"CRON_TZ=UTC 0 9 * * 1-5",
+		},
+		{
+			[]*discordgo.ApplicationCommandInteractionDataOption{stringOption("repeat", "hours")},
+			"That doesn't repeat properly: say how many hours apart with the hours option",
+			"",
+		},
+		{
+			[]*discordgo.ApplicationCommandInteractionDataOption{stringOption("repeat", "daily"), stringOption("days", "mon")},
+			"That doesn't repeat properly: days only goes with repeating weekly",
+			"",
+		},
+		{
+			[]*discordgo.ApplicationCommandInteractionDataOption{intOption("times", 2)},
+			"That doesn't repeat properly: until and times only go with repeat",
+			"",
+		},
+		{
+			[]*discordgo.ApplicationCommandInteractionDataOption{stringOption("repeat", "weekly"), stringOption("days", "someday")},
+			"That doesn't repeat properly: \"someday\" isn't a day of the week",
+			"",
+		},
+		{
+			[]*discordgo.ApplicationCommandInteractionDataOption{stringOption("repeat", "cron"), stringOption("cron", "every tuesday")},
+			"That doesn't repeat properly: \"every tuesday\" isn't a cron expression I understand: expected exactly 5 fields, found 2: [every tuesday]",
+			"",
+		},
+		{
+			[]*discordgo.ApplicationCommandInteractionDataOption{stringOption("repeat", "daily"), stringOption("until", "2099-12-24")},
+			"That stops repeating before the first reminder",
+			"",
+		},
+	}
+	for _, test := range tests {
+		useTestBot(t)
+		ctx := context.Background()
+		options := append([]*discordgo.ApplicationCommandInteractionDataOption{stringOption("reminder", "feed the bird"), stringOption("when", "2099-12-25 10:30")}, test.options...)
+		fake := newFakeSession()
+		handleInteraction(ctx, fake, slashCommand("reminder", options...))
+		if len(fake.responses) != 1 || fake.responses[0].Data.Content != test.want {
+			t.Errorf("Got responses %+v, want %q", fake.responses, test.want)
+			continue
+		}
+		reminders, err := db.DueReminders(ctx, time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.repeat == "" {
+			if len(reminders) != 0 {
+				t.Errorf("Saved %+v after refusing", reminders)
+			}
+		} else if len(reminders) != 1 || reminders[0].Repeat != test.repeat {
+			t.Errorf("Saved %+v, want it repeating on %q", reminders, test.repeat)
+		}
+	}
+}
+
+func TestNextReminder(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A Friday morning, the day before the clocks go back
+	first := time.Date(2026, 10, 24, 9, 0, 0, 0, london)
+	tests := []struct {
+		name string
+		r    reminder
+		now  time.Time
+		want time.Time
+	}{
+		{"one-off", reminder{Date: first}, first, time.Time{}},
+		{"daily keeps to local time over a clock change", reminder{Date: first, Repeat: "CRON_TZ=Europe/London 0 9 * * *"}, first, time.Date(2026, 10, 25, 9, 0, 0, 0, london)},
+		{"weekly", reminder{Date: first, Repeat: "CRON_TZ=Europe/London 0 9 * * 1,3"}, first, time.Date(2026, 10, 26, 9, 0, 0, 0, london)},
+		{"hourly", reminder{Date: first, Repeat: "@every 6h"}, first, first.Add(6 * time.Hour)},
+		{"missed repeats are skipped", reminder{Date: first, Repeat: "@every 1h"}, first.Add(150 * time.Minute), first.Add(3 * time.Hour)},
+		{"last of its times", reminder{Date: first, Repeat: "@every 1h", Times: 3, Sent: 2}, first, time.Time{}},
+		{"times left", reminder{Date: first, Repeat: "@every 1h", Times: 3, Sent: 1}, first, first.Add(time.Hour)},
+		{"past its end", reminder{Date: first, Repeat: "@every 1h", Until: first.Add(30 * time.Minute)}, first, time.Time{}},
+		{"up to its end", reminder{Date: first, Repeat: "@every 1h", Until: first.Add(time.Hour)}, first, first.Add(time.Hour)},
+		{"never comes round", reminder{Date: first, Repeat: "0 9 30 2 *"}, first, time.Time{}},
+		{"bad schedule", reminder{Date: first, Repeat: "whenever"}, first, time.Time{}},
+	}
+	for _, test := range tests {
+		got, again := nextReminder(test.r, test.now)
+		if again != !test.want.IsZero() || !got.Equal(test.want) {
+			t.Errorf("%v: got %v, %v, want %v", test.name, got, again, test.want)
+		}
+	}
+}
+
+func TestRepeatingReminderMovesOn(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	due := time.Now().Add(-time.Minute).Truncate(time.Second)
+	_, err := db.AddReminder(ctx, reminder{UserID: "100", Reminder: "feed the bird", Date: due, Repeat: "@every 24h", Times: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := newFakeSession()
+	checkReminders(ctx, fake)
+	next := due.Add(24 * time.Hour)
+	want := []string{"Hi there! You asked me to remind you about feed the bird - this is that reminder! I'll remind you again <t:" + strconv.FormatInt(next.Unix(), 10) + ":R>"}
+	if got := fake.messages["dm-100"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Sent %q, want %q", got, want)
+	}
+	left, err := db.DueReminders(ctx, next.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 1 || !left[0].Date.Equal(next) || left[0].Sent != 1 {
+		t.Fatalf("Left %+v, want it moved on a day", left)
+	}
+
+	// The second time is the last
+	fake = newFakeSession()
+	checkReminders(ctx, fake)
+	if got := fake.messages["dm-100"]; len(got) != 0 {
+		t.Errorf("Sent %q before it was due again", got)
+	}
+	left[0].Date = due
+	if err := db.UpdateReminder(ctx, left[0]); err != nil {
+		t.Fatal(err)
+	}
+	checkReminders(ctx, fake)
+	want = []string{"Hi there! You asked me to remind you about feed the bird - this is that reminder!"}
+	if got := fake.messages["dm-100"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Sent %q, want %q", got, want)
+	}
+	if left, _ := db.DueReminders(ctx, next.Add(time.Minute)); len(left) != 1 || !left[0].Delivered {
+		t.Errorf("Reminder wasn't finished after its last time: %+v", left)
+	}
+}
+
+func TestReminderClaimedElsewhereIsLeftAlone(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	due := time.Now().Add(-time.Minute).Truncate(time.Second)
+	id, err := db.AddReminder(ctx, reminder{UserID: "100", Reminder: "feed the bird", Date: due, ClaimedBy: "other", LeaseUntil: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := newFakeSession()
+	checkReminders(ctx, fake)
+	if len(fake.sent) != 0 {
+		t.Fatalf("Sent %q while another instance had it", fake.messages["dm-100"])
+	}
+
+	// The other instance died before sending it
+	r, _ := db.Reminder(ctx, id)
+	r.LeaseUntil = time.Now().Add(-time.Second)
+	db.UpdateReminder(ctx, *r)
+	checkReminders(ctx, fake)
+	checkReminders(ctx, fake)
+	if len(fake.sent) != 1 {
+		t.Errorf("Sent %q, want it sent once", fake.messages["dm-100"])
+	}
+}
+
+func TestInterruptedReminderIsNotSentTwice(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	due := time.Now().Add(-time.Minute).Truncate(time.Second)
+	r := reminder{UserID: "100", Reminder: "feed the bird", Date: due, ClaimedBy: "dead", LeaseUntil: time.Now().Add(-time.Second)}
+	id, err := db.AddReminder(ctx, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The instance that claimed it sent it then stopped before saving that it had
+	fake := newFakeSession()
+	r.ID = id
+	buttons, _ := reminderButtons(r)
+	earlier, _ := fake.ChannelMessageSendComplex("dm-100", &discordgo.MessageSend{Content: "Hi there!", Components: buttons})
+	checkReminders(ctx, fake)
+	if len(fake.sent) != 1 {
+		t.Errorf("Sent %q, want only the earlier message", fake.messages["dm-100"])
+	}
+	got, _ := db.Reminder(ctx, id)
+	if !got.Delivered || got.MessageID != earlier.ID || got.ClaimedBy != "" {
+		t.Errorf("Reminder is %+v, want it delivered in message %v", got, earlier.ID)
+	}
+}
+
+func TestReminderRetriesThenFallsBack(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	due := time.Now().Add(-time.Minute).Truncate(time.Second)
+	id, err := db.AddReminder(ctx, reminder{GuildID: "1", UserID: "100", ChannelID: "50", Reminder: "feed the bird", Date: due})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := newFakeSession()
+	fake.failing = map[string]error{"dm-100": errors.New("discord is down")}
+	for n := 1; n <= reminderDMAttempts; n++ {
+		checkReminders(ctx, fake)
+		r, _ := db.Reminder(ctx, id)
+		if r.Attempts != n || r.Delivered || !r.Date.Equal(due) || r.Failure != "discord is down" {
+			t.Fatalf("After %d tries reminder is %+v", n, r)
+		}
+		if wait := time.Until(r.RetryAt); wait < reminderRetryDelay<<uint(n-1)-time.Second || wait > reminderRetryDelay<<uint(n-1) {
+			t.Errorf("After %d tries it's tried again in %v", n, wait)
+		}
+		// Nothing happens until it's time to try again
+		checkReminders(ctx, fake)
+		if again, _ := db.Reminder(ctx, id); again.Attempts != n {
+			t.Fatalf("Tried again early: %+v", again)
+		}
+		r.RetryAt = time.Now().Add(-time.Second)
+		db.UpdateReminder(ctx, *r)
+	}
+
+	checkReminders(ctx, fake)
+	if len(fake.sent) != 1 || fake.sent[0].Content != "<@100> Hi there! You asked me to remind you about feed the bird - this is that reminder!" || !reflect.DeepEqual(fake.sent[0].AllowedMentions.Users, []string{"100"}) {
+		t.Fatalf("Sent %+v, want a mention in the channel it was set in", fake.sent)
+	}
+	if got := fake.messages["50"]; len(got) != 1 {
+		t.Errorf("Sent to the channel %q", got)
+	}
+	if r, _ := db.Reminder(ctx, id); !r.Delivered || r.Attempts != 0 || !r.RetryAt.IsZero() {
+		t.Errorf("Delivered reminder is %+v", r)
+	}
+}
+
+func TestClosedDMsFallBackStraightAway(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	db.AddReminder(ctx, reminder{GuildID: "1", UserID: "100", ChannelID: "50", Reminder: "feed the bird", Date: time.Now().Add(-time.Minute)})
+
+	fake := newFakeSession()
+	fake.failing = map[string]error{"dm-100": &discordgo.RESTError{Response: &http.Response{Status: "403 Forbidden"}, Message: &discordgo.APIErrorMessage{Code: discordgo.ErrCodeCannotSendMessagesToThisUser}}}
+	checkReminders(ctx, fake)
+	checkReminders(ctx, fake)
+	if got := fake.messages["50"]; len(got) != 1 {
+		t.Errorf("Sent to the channel %q, want it sent once DMs were refused", got)
+	}
+}
+
+func TestUndeliverableReminderIsDead(t *testing.T) {
+	useTestBot(t)
+	conf.Guilds = map[string]guildConfig{"1": {AdminChannel: "77"}}
+	ctx := context.Background()
+	id, err := db.AddReminder(ctx, reminder{GuildID: "1", UserID: "100", Reminder: "feed the bird", Date: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := newFakeSession()
+	fake.noDMs = map[string]error{"100": errors.New("who?")}
+	for n := 0; n < reminderAttempts; n++ {
+		checkReminders(ctx, fake)
+		r, _ := db.Reminder(ctx, id)
+		r.RetryAt = time.Now().Add(-time.Second)
+		db.UpdateReminder(ctx, *r)
+	}
+	r, _ := db.Reminder(ctx, id)
+	if !r.Dead || r.Attempts != reminderAttempts || r.Failure != "DMs didn't work and there's no channel to fall back to" {
+		t.Errorf("Reminder is %+v, want it given up on", r)
+	}
+	if got := fake.messages["77"]; len(got) != 1 || !strings.HasPrefix(got[0], "I couldn't deliver a reminder for <@100> after 6 tries") {
+		t.Errorf("Told admins %q", got)
+	}
+	if due, _ := db.DueReminders(ctx, time.Now()); len(due) != 0 {
+		t.Errorf("Dead reminder is still due: %+v", due)
+	}
+
+	undelivered := func(member *discordgo.Member) string {
+		i := slashCommand("reminders", &discordgo.ApplicationCommandInteractionDataOption{Name: "undelivered", Type: discordgo.ApplicationCommandOptionSubCommand})
+		i.Member = member
+		fake := newFakeSession()
+		handleInteraction(ctx, fake, i)
+		return fake.responses[0].Data.Content
+	}
+	if got := undelivered(&discordgo.Member{User: &discordgo.User{ID: "100"}}); got != "Only admins can see everyone's undelivered reminders" {
+		t.Errorf("Non-admin got %q", got)
+	}
+	admin := &discordgo.Member{User: &discordgo.User{ID: "999"}}
+	if got := undelivered(admin); !strings.HasPrefix(got, "These reminders couldn't be delivered:\n`1` ") || !strings.HasSuffix(got, "for <@100> - feed the bird, tried 6 times: DMs didn't work and there's no channel to fall back to") {
+		t.Errorf("Admin got %q", got)
+	}
+
+	// Editing it gives it another go
+	fake = newFakeSession()
+	handleInteraction(ctx, fake, slashCommand("reminders", &discordgo.ApplicationCommandInteractionDataOption{Name: "edit", Type: discordgo.ApplicationCommandOptionSubCommand, Options: []*discordgo.ApplicationCommandInteractionDataOption{stringOption("id", id), stringOption("when", "in 1 hour")}}))
+	if r, _ := db.Reminder(ctx, id); r.Dead || r.Attempts != 0 {
+		t.Errorf("Edited reminder is %+v", r)
+	}
+	if got := undelivered(admin); got != "Every reminder here has been delivered fine" {
+		t.Errorf("Admin got %q after it was edited", got)
+	}
+}
+
+func TestRemindAboutMessage(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	fake := newFakeSession()
+	about, _ := fake.ChannelMessageSendComplex("50", &discordgo.MessageSend{Content: "Race starts at 7\nDon't be late"})
+
+	menu := &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:        "900",
+		Type:      discordgo.InteractionApplicationCommand,
+		GuildID:   "1",
+		ChannelID: "50",
+		Member:    &discordgo.Member{User: &discordgo.User{ID: "100", Username: "banjo"}},
+		Data: discordgo.ApplicationCommandInteractionData{Name: "Remind me about this", CommandType: discordgo.MessageApplicationCommand, TargetID: about.ID, Resolved: &discordgo.ApplicationCommandInteractionDataResolved{
+			Messages: map[string]*discordgo.Message{about.ID: about},
+		}},
+	}}
+	handleInteraction(ctx, fake, menu)
+	if len(fake.responses) != 1 || fake.responses[0].Type != discordgo.InteractionResponseModal {
+		t.Fatalf("Got responses %+v, want a modal", fake.responses)
+	}
+	modal := fake.responses[0].Data
+	if len(modal.Components) != 2 {
+		t.Errorf("Modal has %d inputs, want just when and note", len(modal.Components))
+	}
+
+	submit := func(when, note string) *discordgo.InteractionResponseData {
+		fake.responses = nil
+		handleInteraction(ctx, fake, &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+			Type:      discordgo.InteractionModalSubmit,
+			GuildID:   "1",
+			ChannelID: "50",
+			Member:    &discordgo.Member{User: &discordgo.User{ID: "100", Username: "banjo"}},
+			Data: discordgo.ModalSubmitInteractionData{CustomID: modal.CustomID, Components: []discordgo.MessageComponent{
+				&discordgo.ActionsRow{Components: []discordgo.MessageComponent{&discordgo.TextInput{CustomID: "when", Value: when}}},
+				&discordgo.ActionsRow{Components: []discordgo.MessageComponent{&discordgo.TextInput{CustomID: "note", Value: note}}},
+			}},
+		}})
+		if len(fake.responses) != 1 {
+			t.Fatalf("Got responses %+v", fake.responses)
+		}
+		return fake.responses[0].Data
+	}
+	if got := submit("whenever", ""); got.Flags != 64 || !strings.HasPrefix(got.Content, "I couldn't work out when that is") {
+		t.Errorf("Got %+v for a bad time", got)
+	}
+	if got := submit("2099-12-25 10:00", ""); got.Content != "Okay, I'll remind you of this message on Friday 25 December 2099 at 10:00 UTC (<t:4101876000:R>)" {
+		t.Errorf("Got %q", got.Content)
+	}
+
+	r, err := db.Reminder(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ChannelID != "50" || r.AboutMessageID != about.ID || r.Quote != about.Content {
+		t.Errorf("Saved %+v", r)
+	}
+	if _, err := db.Setting(ctx, remindAboutQuoteSetting+"900"); err != errNotFound {
+		t.Errorf("Kept the quote after saving the reminder, got %v", err)
+	}
+	r.Date = time.Now().Add(-time.Minute)
+	db.UpdateReminder(ctx, *r)
+	checkReminders(ctx, fake)
+	want := []string{"Hi there! You asked me to remind you about this message - this is that reminder!\n> Race starts at 7\n> Don't be late\nhttps://discord.com/channels/1/50/" + about.ID}
+	if got := fake.messages["dm-100"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Sent %q, want %q", got, want)
+	}
+}
+
+func TestAnnouncementReminders(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	racers := &discordgo.Role{ID: "300", Name: "racers", Mentionable: true}
+	mods := &discordgo.Role{ID: "301", Name: "mods"}
+	announce := func(userID string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionResponseData {
+		i := slashCommand("reminder", append([]*discordgo.ApplicationCommandInteractionDataOption{
+			stringOption("reminder", "race starts in 15 minutes @everyone"),
+			stringOption("when", "2099-12-25 10:00"),
+		}, options...)...)
+		i.ChannelID = "50"
+		i.Member.User.ID = userID
+		i.Data = discordgo.ApplicationCommandInteractionData{Name: "reminder", Options: i.ApplicationCommandData().Options, Resolved: &discordgo.ApplicationCommandInteractionDataResolved{
+			Roles:    map[string]*discordgo.Role{racers.ID: racers, mods.ID: mods},
+			Channels: map[string]*discordgo.Channel{"60": {ID: "60", Type: discordgo.ChannelTypeGuildText}, "70": {ID: "70", Type: discordgo.ChannelTypeGuildText}},
+		}}
+		fake := newFakeSession()
+		fake.channelPermissions = map[string]int64{"70": discordgo.PermissionViewChannel}
+		handleInteraction(ctx, fake, i)
+		if len(fake.responses) != 1 {
+			t.Fatalf("Got responses %+v", fake.responses)
+		}
+		return fake.responses[0].Data
+	}
+	channel := &discordgo.ApplicationCommandInteractionDataOption{Name: "channel", Type: discordgo.ApplicationCommandOptionChannel, Value: "60"}
+	ping := func(id string) *discordgo.ApplicationCommandInteractionDataOption {
+		return &discordgo.ApplicationCommandInteractionDataOption{Name: "ping", Type: discordgo.ApplicationCommandOptionMentionable, Value: id}
+	}
+
+	if got := announce("100", channel); got.Content != "You need the announcer permission to post reminders in channels or ping people" {
+		t.Errorf("Non-announcer got %q", got.Content)
+	}
+	db.AddGrant(ctx, grant{GuildID: "1", Capability: capAnnouncer, UserID: "100"})
+	if got := announce("100", ping(mods.ID)); got.Content != "You can't ping mods yourself, so I can't do it for you" {
+		t.Errorf("Pinging a role that can't be pinged got %q", got.Content)
+	}
+	readOnly := &discordgo.ApplicationCommandInteractionDataOption{Name: "channel", Type: discordgo.ApplicationCommandOptionChannel, Value: "70"}
+	if got := announce("100", readOnly); got.Content != "You can't post in <#70> yourself, so I can't do it for you" {
+		t.Errorf("Posting in a read-only channel got %q", got.Content)
+	}
+	got := announce("100", channel, ping(racers.ID))
+	if want := "Okay, I'll post race starts in 15 minutes @everyone in <#60> pinging <@&300> on Friday 25 December 2099 at 10:00 UTC (<t:4101876000:R>)"; got.Content != want {
+		t.Errorf("Got %q, want %q", got.Content, want)
+	}
+	if got.AllowedMentions == nil || len(got.AllowedMentions.Parse) != 0 || len(got.AllowedMentions.Roles) != 0 {
+		t.Errorf("Confirmation could ping %+v", got.AllowedMentions)
+	}
+
+	r, _ := db.Reminder(ctx, "1")
+	r.Date = time.Now().Add(-time.Minute)
+	db.UpdateReminder(ctx, *r)
+	fake := newFakeSession()
+	checkReminders(ctx, fake)
+	if len(fake.sent) != 1 || len(fake.messages["dm-100"]) != 0 {
+		t.Fatalf("Sent %+v", fake.messages)
+	}
+	sent := fake.sent[0]
+	if sent.Content != "<@&300> race starts in 15 minutes @everyone" || len(fake.messages["60"]) != 1 {
+		t.Errorf("Posted %q to %q", sent.Content, fake.messages)
+	}
+	if !reflect.DeepEqual(sent.AllowedMentions, &discordgo.MessageAllowedMentions{Roles: []string{"300"}}) {
+		t.Errorf("Posted allowing mentions %+v, want just the role", sent.AllowedMentions)
+	}
+
+	// Pinging without a channel posts it where it was set up
+	announce("100", ping("200"))
+	r, _ = db.Reminder(ctx, "2")
+	if r.PostChannelID != "50" || r.PingUserID != "200" {
+		t.Errorf("Saved %+v", r)
+	}
+}
+
+// buttonClick clicks a button on a message the bot DMed to user 100
+func buttonClick(message *discordgo.MessageSend, label string) *discordgo.InteractionCreate {
+	var id string
+	for _, row := range message.Components {
+		for _, c := range row.(discordgo.ActionsRow).Components {
+			if b := c.(discordgo.Button); b.Label == label {
+				id = b.CustomID
+			}
+		}
+	}
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		Type:    discordgo.InteractionMessageComponent,
+		User:    &discordgo.User{ID: "100", Username: "banjo"},
+		Message: &discordgo.Message{Content: message.Content},
+		Data:    discordgo.MessageComponentInteractionData{CustomID: id},
+	}}
+}
+
+func TestSnoozeAndDone(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	if _, err := db.AddReminder(ctx, reminder{UserID: "100", Reminder: "feed the bird", Date: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakeSession()
+	checkReminders(ctx, fake)
+	delivered := fake.sent[0]
+
+	click := newFakeSession()
+	handleInteraction(ctx, click, buttonClick(delivered, "Snooze 1h"))
+	if len(click.responses) != 1 || click.responses[0].Type != discordgo.InteractionResponseUpdateMessage || !strings.HasPrefix(click.responses[0].Data.Content, delivered.Content+"\n\nSnoozed until ") {
+		t.Fatalf("Got responses %+v", click.responses)
+	}
+	r, err := db.Reminder(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Delivered || !r.Snoozed || r.Date.Sub(time.Now()) < 59*time.Minute {
+		t.Errorf("Snoozed to %+v, want it due again in an hour", r)
+	}
+
+	// The message's buttons are stale now the reminder's moved
+	click = newFakeSession()
+	handleInteraction(ctx, click, buttonClick(delivered, "Done"))
+	if len(click.responses) != 1 || click.responses[0].Data.Content != "That reminder's been moved since, so use the newest message about it" {
+		t.Errorf("Got responses %+v", click.responses)
+	}
+
+	r.Date = time.Now().Add(-time.Minute)
+	db.UpdateReminder(ctx, *r)
+	fake = newFakeSession()
+	checkReminders(ctx, fake)
+	click = newFakeSession()
+	handleInteraction(ctx, click, buttonClick(fake.sent[0], "Done"))
+	if len(click.responses) != 1 || click.responses[0].Data.Content != fake.sent[0].Content+"\n\nDone" || len(click.responses[0].Data.Components) != 0 {
+		t.Errorf("Got responses %+v", click.responses)
+	}
+	if _, err := db.Reminder(ctx, "1"); err != errNotFound {
+		t.Errorf("Done didn't clear the reminder up: %v", err)
+	}
+}
+
+func TestSnoozeIsOnlyForTheOwner(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	db.AddReminder(ctx, reminder{UserID: "100", Reminder: "feed the bird", Date: time.Now().Add(-time.Minute)})
+	fake := newFakeSession()
+	checkReminders(ctx, fake)
+
+	i := buttonClick(fake.sent[0], "Snooze 10m")
+	i.User = &discordgo.User{ID: "101", Username: "kazooie"}
+	click := newFakeSession()
+	handleInteraction(ctx, click, i)
+	if len(click.responses) != 1 || click.responses[0].Data.Content != "That isn't your reminder" {
+		t.Errorf("Got responses %+v", click.responses)
+	}
+}
+
+func TestManagingReminders(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	start := time.Date(2099, 1, 1, 9, 0, 0, 0, time.UTC)
+	for n := 0; n < 12; n++ {
+		db.AddReminder(ctx, reminder{GuildID: "1", UserID: "100", Reminder: "reminder " + strconv.Itoa(n), Date: start.AddDate(0, 0, n)})
+	}
+	db.AddReminder(ctx, reminder{GuildID: "2", UserID: "100", Reminder: "in another guild", Date: start})
+	db.AddReminder(ctx, reminder{GuildID: "1", UserID: "101", Reminder: "someone else's", Date: start})
+
+	subcommand := func(name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *fakeSession {
+		fake := newFakeSession()
+		handleInteraction(ctx, fake, slashCommand("reminders", &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionSubCommand, Options: options}))
+		if len(fake.responses) != 1 {
+			t.Fatalf("Got responses %+v", fake.responses)
+		}
+		return fake
+	}
+
+	list := subcommand("list").responses[0].Data
+	if !strings.HasPrefix(list.Content, "Your reminders (page 1 of 2):\n`1` <t:4070941200:f> - reminder 0\n") || strings.Count(list.Content, "\n") != 11 || len(list.Components) != 1 {
+		t.Errorf("First page is %q with %d rows of buttons", list.Content, len(list.Components))
+	}
+	next := list.Components[0].(discordgo.ActionsRow).Components[1].(discordgo.Button)
+	fake := newFakeSession()
+	handleInteraction(ctx, fake, &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		Type:    discordgo.InteractionMessageComponent,
+		GuildID: "1",
+		Member:  &discordgo.Member{User: &discordgo.User{ID: "100", Username: "banjo"}},
+		Data:    discordgo.MessageComponentInteractionData{CustomID: next.CustomID},
+	}})
+	if got := fake.responses[0].Data.Content; got != "Your reminders (page 2 of 2):\n`11` <t:4071805200:f> - reminder 10\n`12` <t:4071891600:f> - reminder 11\n" {
+		t.Errorf("Second page is %q", got)
+	}
+
+	if got := subcommand("cancel", stringOption("id", "14")).responses[0].Data.Content; got != "I can't find a reminder of yours with the ID 14. Check /reminders list" {
+		t.Errorf("Cancelling someone else's reminder got %q", got)
+	}
+	if got := subcommand("cancel", stringOption("id", "1")).responses[0].Data.Content; got != "Okay, I won't remind you of reminder 0" {
+		t.Errorf("Cancelling got %q", got)
+	}
+	if _, err := db.Reminder(ctx, "1"); err != errNotFound {
+		t.Errorf("Cancelled reminder is still there: %v", err)
+	}
+
+	got := subcommand("edit", stringOption("id", "2"), stringOption("reminder", "water the plants"), stringOption("when", "2099-06-01 12:00")).responses[0].Data.Content
+	if got != "Okay, I'll remind you of water the plants on Monday 1 June 2099 at 12:00 UTC (<t:4083998400:R>)" {
+		t.Errorf("Editing got %q", got)
+	}
+	if r, _ := db.Reminder(ctx, "2"); r.Reminder != "water the plants" || r.Date.Unix() != 4083998400 {
+		t.Errorf("Edited to %+v", r)
+	}
+	if got := subcommand("edit", stringOption("id", "3")).responses[0].Data.Content; got != "Say what to change, with reminder or when" {
+		t.Errorf("Editing nothing got %q", got)
+	}
+}
+
+func TestEditingRepeatingAndClaimedReminders(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	start := time.Date(2099, 1, 1, 9, 0, 0, 0, time.UTC)
+	db.AddReminder(ctx, reminder{GuildID: "1", UserID: "100", Reminder: "weekdays", Date: start, Repeat: "CRON_TZ=UTC 0 9 * * 1,2,3,4,5"})
+	db.AddReminder(ctx, reminder{GuildID: "1", UserID: "100", Reminder: "monthly", Date: start, Repeat: "CRON_TZ=UTC 0 9 1 * *"})
+	db.AddReminder(ctx, reminder{GuildID: "1", UserID: "100", Reminder: "going off", Date: start, ClaimedBy: "other", LeaseUntil: time.Now().Add(time.Minute)})
+
+	subcommand := func(name string, options ...*discordgo.ApplicationCommandInteractionDataOption) string {
+		fake := newFakeSession()
+		handleInteraction(ctx, fake, slashCommand("reminders", &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionSubCommand, Options: options}))
+		if len(fake.responses) != 1 {
+			t.Fatalf("Got responses %+v", fake.responses)
+		}
+		return fake.responses[0].Data.Content
+	}
+
+	// Moving a repeating reminder moves every time it goes off, not just the next
+	subcommand("edit", stringOption("id", "1"), stringOption("when", "2099-06-01 12:30"))
+	if r, _ := db.Reminder(ctx, "1"); r.Repeat != "CRON_TZ=UTC 30 12 * * 1,2,3,4,5" {
+		t.Errorf("Edited to %+v, want the schedule moved too", r)
+	}
+	if got := subcommand("edit", stringOption("id", "2"), stringOption("when", "2099-06-01 12:30")); !strings.HasPrefix(got, "That reminder repeats on a cron schedule") {
+		t.Errorf("Editing a cron reminder got %q", got)
+	}
+
+	// Nothing changes under an instance that's delivering it
+	if got := subcommand("cancel", stringOption("id", "3")); got != reminderBusy {
+		t.Errorf("Cancelling a claimed reminder got %q", got)
+	}
+	if got := subcommand("edit", stringOption("id", "3"), stringOption("reminder", "something else")); got != reminderBusy {
+		t.Errorf("Editing a claimed reminder got %q", got)
+	}
+	if r, err := db.Reminder(ctx, "3"); err != nil || r.Reminder != "going off" {
+		t.Errorf("Claimed reminder is now %+v, %v", r, err)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// customIDHandler handles a button click, select menu choice or modal submission. The args are
+// whatever state was put in the custom ID after its prefix
+type customIDHandler func(ctx context.Context, s botSession, i *discordgo.InteractionCreate, args []string)
+
+// customIDRoute is how custom IDs with a given prefix are handled
+type customIDRoute struct {
+	Handler customIDHandler
+	// Args is how many args its custom IDs carry. Ones with any other number are refused
+	Args int
+	// Timeout is how long the handler has, like a command's, defaulting to defaultTimeout
+	Timeout time.Duration
+}
+
+// components and modals are keyed by the prefix of the custom IDs they handle
+var (
+	components = map[string]customIDRoute{
+		"reminder-snooze":   {Handler: snoozeReminder, Args: 3},
+		"reminder-done":     {Handler: finishReminder, Args: 2},
+		"reminders-page":    {Handler: turnRemindersPage, Args: 1},
+		"musicsetup-save":   {Handler: saveMonthSetup, Timeout: 30 * time.Second},
+		"musicsetup-cancel": {Handler: cancelMonthSetup},
+	}
+	modals = map[string]customIDRoute{
+		"remind-about": {Handler: remindAboutSubmit, Args: 3},
+	}
+)
+
+// customID builds a custom ID that will be routed to the handler registered under prefix,
+// carrying args with it. Discord only allows 100 characters, so args need to be short, like IDs
+func customID(prefix string, args ...string) (string, error) {
+	parts := []string{prefix}
+	for _, arg := range args {
+		parts = append(parts, url.QueryEscape(arg))
+	}
+	id := strings.Join(parts, ":")
+	if len(id) > 100 {
+		return "", fmt.Errorf("custom ID %q is over Discord's 100 character limit", id)
+	}
+	return id, nil
+}
+
+// parseCustomID splits a custom ID made by customID back into its prefix and args
+func parseCustomID(id string) (string, []string, error) {
+	parts := strings.Split(id, ":")
+	args := make([]string, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		arg, err := url.QueryUnescape(part)
+		if err != nil {
+			return "", nil, fmt.Errorf("bad custom ID %q: %v", id, err)
+		}
+		args = append(args, arg)
+	}
+	return parts[0], args, nil
+}
+
+// routeInteraction sends an interaction to whatever handles its type: commands by name, and
+// components and modals by custom ID prefix
+func routeInteraction(ctx context.Context, s botSession, i *discordgo.InteractionCreate) {
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		runCommand(ctx, s, i)
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		runAutocomplete(ctx, s, i)
+	case discordgo.InteractionMessageComponent:
+		runCustomID(ctx, s, i, components, i.MessageComponentData().CustomID)
+	case discordgo.InteractionModalSubmit:
+		runCustomID(ctx, s, i, modals, i.ModalSubmitData().CustomID)
+	default:
+		log.Printf("Got interaction of unknown type %v", i.Type)
+	}
+}
+
+// customIDTimeout gets how long a component or modal's handler has, or 0 if it doesn't say
+func customIDTimeout(i *discordgo.InteractionCreate) time.Duration {
+	var routes map[string]customIDRoute
+	var id string
+	switch i.Type {
+	case discordgo.InteractionMessageComponent:
+		routes, id = components, i.MessageComponentData().CustomID
+	case discordgo.InteractionModalSubmit:
+		routes, id = modals, i.ModalSubmitData().CustomID
+	default:
+		return 0
+	}
+	prefix, _, err := parseCustomID(id)
+	if err != nil {
+		return 0
+	}
+	return routes[prefix].Timeout
+}
+
+func runCustomID(ctx context.Context, s botSession, i *discordgo.InteractionCreate, routes map[string]customIDRoute, id string) {
+	prefix, args, err := parseCustomID(id)
+	route, ok := routes[prefix]
+	switch {
+	case err != nil:
+		log.Print(err)
+	case !ok:
+		// Most likely a message from before a restart that changed what the bot understands
+		log.Printf("Nothing handles custom ID %q", id)
+	case len(args) != route.Args:
+		log.Printf("Custom ID %q has %d args, want %d", id, len(args), route.Args)
+	default:
+		route.Handler(ctx, s, i, args)
+		return
+	}
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags:   64,
+			Content: "I don't know what to do with that any more, sorry",
+		},
+	})
+}
+
+// runAutocomplete asks a command for suggestions for whichever option the user is typing in
+func runAutocomplete(ctx context.Context, s botSession, i *discordgo.InteractionCreate) {
+	data := i.ApplicationCommandData()
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	if c := findCommand(data.Name); c != nil && c.Autocomplete != nil {
+		// Options are half typed, so don't insist on the required ones
+		opts, err := parseOptions(c, data, false)
+		if err != nil {
+			log.Printf("Bad autocomplete options for /%v: %v", c.Name, err)
+		} else {
+			choices = c.Autocomplete(ctx, i, opts)
+		}
+	}
+	if len(choices) > 25 {
+		choices = choices[:25]
+	}
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	})
+}
+
+// modalValues gets what was typed into each text input of a submitted modal, keyed by custom ID
+func modalValues(data discordgo.ModalSubmitInteractionData) map[string]string {
+	values := map[string]string{}
+	for _, row := range data.Components {
+		actions, ok := row.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, c := range actions.Components {
+			if input, ok := c.(*discordgo.TextInput); ok {
+				values[input.CustomID] = input.Value
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestCustomIDRoundTrip(t *testing.T) {
+	id, err := customID("snooze", "42", "1h:30m", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix, args, err := parseCustomID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefix != "snooze" || !reflect.DeepEqual(args, []string{"42", "1h:30m", ""}) {
+		t.Errorf("%q came back as %q %q", id, prefix, args)
+	}
+	if id, err := customID("snooze", strings.Repeat("9", 100)); err == nil {
+		t.Errorf("Built %q, which is too long for Discord", id)
+	}
+}
+
+func TestComponentRouting(t *testing.T) {
+	var got []string
+	components["test"] = customIDRoute{Args: 2, Handler: func(ctx context.Context, s botSession, i *discordgo.InteractionCreate, args []string) {
+		got = args
+	}}
+	defer delete(components, "test")
+
+	click := func(args ...string) *fakeSession {
+		id, _ := customID("test", args...)
+		fake := newFakeSession()
+		routeInteraction(context.Background(), fake, &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+			Type: discordgo.InteractionMessageComponent,
+			Data: discordgo.MessageComponentInteractionData{CustomID: id},
+		}})
+		return fake
+	}
+
+	fake := click("a", "b")
+	if !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Handler got %q", got)
+	}
+	if len(fake.responses) != 0 {
+		t.Errorf("Router responded itself: %+v", fake.responses)
+	}
+
+	// A stale ID with the wrong number of args never reaches the handler
+	got = nil
+	fake = click("a")
+	if got != nil || len(fake.responses) != 1 || !strings.Contains(fake.responses[0].Data.Content, "any more") {
+		t.Errorf("Handler got %q and router responded %+v, want it refused", got, fake.responses)
+	}
+}
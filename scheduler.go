@@ -0,0 +1,176 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// reminderResync is how often the store is checked for reminders, in case one was missed or
+// changed behind the scheduler's back. Reminders due up to the check after next are queued
+const reminderResync = 15 * time.Minute
+
+// reminderLease is how long an instance has to deliver a reminder it's claimed before someone
+// else can take it over
+const reminderLease = 2 * time.Minute
+
+// instanceID tells this run of the bot apart from any others sharing the store, including
+// earlier runs on the same host
+var instanceID = newInstanceID()
+
+func newInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%v-%d-%v", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// reminderTimers fires reminders when they're due. The store is always the record of what's
+// due, so the scheduler only needs IDs and times
+var reminderTimers = newReminderScheduler()
+
+type scheduledReminder struct {
+	id string
+	at time.Time
+}
+
+// reminderQueue is a heap of reminders, soonest first
+type reminderQueue []scheduledReminder
+
+func (q reminderQueue) Len() int            { return len(q) }
+func (q reminderQueue) Less(a, b int) bool  { return q[a].at.Before(q[b].at) }
+func (q reminderQueue) Swap(a, b int)       { q[a], q[b] = q[b], q[a] }
+func (q *reminderQueue) Push(x interface{}) { *q = append(*q, x.(scheduledReminder)) }
+func (q *reminderQueue) Pop() interface{} {
+	old := *q
+	last := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return last
+}
+
+type reminderScheduler struct {
+	mu    sync.Mutex
+	queue reminderQueue
+	// at has when each reminder is next due. Moving or cancelling a reminder leaves its old
+	// entries in the queue, and they're dropped when they come up and don't match
+	at   map[string]time.Time
+	wake chan struct{}
+}
+
+func newReminderScheduler() *reminderScheduler {
+	return &reminderScheduler{at: map[string]time.Time{}, wake: make(chan struct{}, 1)}
+}
+
+// Schedule queues a reminder for when it's next due, replacing anything queued for it before.
+// Delivered reminders are queued for when they should be cleared up, and dead ones aren't queued
+func (s *reminderScheduler) Schedule(r reminder) {
+	if r.Dead {
+		s.Cancel(r.ID)
+		return
+	}
+	at := r.dueAt()
+	if r.Delivered {
+		at = r.Date.Add(snoozeWindow)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.at[r.ID]; ok && old.Equal(at) {
+		return
+	}
+	s.at[r.ID] = at
+	heap.Push(&s.queue, scheduledReminder{id: r.ID, at: at})
+	// Run might be waiting for something later than this
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Cancel stops a reminder going off
+func (s *reminderScheduler) Cancel(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.at, id)
+}
+
+// due takes everything due by now off the queue, soonest first
+func (s *reminderScheduler) due(now time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for len(s.queue) > 0 && !s.queue[0].at.After(now) {
+		next := heap.Pop(&s.queue).(scheduledReminder)
+		if at, ok := s.at[next.id]; ok && at.Equal(next.at) {
+			delete(s.at, next.id)
+			ids = append(ids, next.id)
+		}
+	}
+	return ids
+}
+
+// next is when the soonest queued reminder is due, or false if nothing's queued
+func (s *reminderScheduler) next() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) == 0 {
+		return time.Time{}, false
+	}
+	return s.queue[0].at, true
+}
+
+// Run delivers reminders as they come due until ctx is cancelled, checking the store when it
+// starts and every reminderResync after. Deliveries and checks all happen here, one at a time,
+// so a reminder can't be delivered twice by both
+func (s *reminderScheduler) Run(ctx context.Context, session botSession) {
+	checkReminders(ctx, session)
+	resync := time.NewTicker(reminderResync)
+	defer resync.Stop()
+	for {
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if at, ok := s.next(); ok {
+			timer = time.NewTimer(time.Until(at))
+			fire = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-resync.C:
+			checkReminders(ctx, session)
+		case <-s.wake:
+		case <-fire:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		for _, id := range s.due(time.Now()) {
+			fireReminder(ctx, session, id)
+		}
+	}
+}
+
+// fireReminder delivers a reminder the scheduler says is due, going by what the store has
+// now in case it's changed since it was queued
+func fireReminder(ctx context.Context, s botSession, id string) {
+	r, err := db.Reminder(ctx, id)
+	if err == errNotFound {
+		return
+	}
+	if err != nil {
+		// The next resync will pick it up
+		log.Printf("Couldn't get due reminder %v: %v", id, err)
+		return
+	}
+	processReminder(ctx, s, *r, time.Now())
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReminderSchedulerQueue(t *testing.T) {
+	s := newReminderScheduler()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	s.Schedule(reminder{ID: "late", Date: now.Add(time.Hour)})
+	s.Schedule(reminder{ID: "soon", Date: now.Add(time.Minute)})
+	s.Schedule(reminder{ID: "moved", Date: now.Add(2 * time.Minute)})
+	s.Schedule(reminder{ID: "cancelled", Date: now.Add(3 * time.Minute)})
+	s.Schedule(reminder{ID: "delivered", Date: now.Add(-snoozeWindow).Add(4 * time.Minute), Delivered: true})
+	s.Schedule(reminder{ID: "moved", Date: now.Add(5 * time.Minute)})
+	s.Cancel("cancelled")
+
+	if at, ok := s.next(); !ok || !at.Equal(now.Add(time.Minute)) {
+		t.Errorf("Next is %v, %v, want a minute from now", at, ok)
+	}
+	if got := s.due(now); len(got) != 0 {
+		t.Errorf("Due before anything should be: %q", got)
+	}
+	want := []string{"soon", "delivered", "moved"}
+	if got := s.due(now.Add(30 * time.Minute)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Due %q, want %q", got, want)
+	}
+	if got := s.due(now.Add(2 * time.Hour)); !reflect.DeepEqual(got, []string{"late"}) {
+		t.Errorf("Due %q, want just late", got)
+	}
+	if _, ok := s.next(); ok {
+		t.Error("Queue should be empty")
+	}
+}
+
+// sentTo gets what's been sent to a channel, safely while the scheduler's running
+func (f *fakeSession) sentTo(channelID string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.messages[channelID]...)
+}
+
+func TestReminderSchedulerDeliversOnTime(t *testing.T) {
+	useTestBot(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+
+	// One that's there at startup, and one added while it's running
+	start := time.Now()
+	db.AddReminder(ctx, reminder{UserID: "100", Reminder: "feed the bird", Date: start.Add(200 * time.Millisecond)})
+	fake := newFakeSession()
+	go func() {
+		reminderTimers.Run(ctx, fake)
+		close(stopped)
+	}()
+	later := reminder{UserID: "101", Reminder: "water the plants", Date: start.Add(400 * time.Millisecond)}
+	later.ID, _ = db.AddReminder(ctx, later)
+	reminderTimers.Schedule(later)
+	cancelled := reminder{UserID: "102", Reminder: "never mind", Date: start.Add(300 * time.Millisecond)}
+	cancelled.ID, _ = db.AddReminder(ctx, cancelled)
+	reminderTimers.Schedule(cancelled)
+	reminderTimers.Cancel(cancelled.ID)
+	db.DeleteReminder(ctx, cancelled.ID)
+
+	if got := fake.sentTo("dm-100"); len(got) != 0 {
+		t.Fatalf("Delivered early: %q", got)
+	}
+	for _, user := range []string{"100", "101"} {
+		deadline := time.Now().Add(2 * time.Second)
+		for len(fake.sentTo("dm-"+user)) == 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if len(fake.sentTo("dm-"+user)) != 1 {
+			t.Fatalf("Reminder for %v wasn't delivered", user)
+		}
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("Took %v to deliver reminders due within half a second", took)
+	}
+	if got := fake.sentTo("dm-102"); len(got) != 0 {
+		t.Errorf("Delivered a cancelled reminder: %q", got)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// botSession is the part of *discordgo.Session the handlers use, so tests can swap in a fake
+type botSession interface {
+	InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error
+	InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	FollowupMessageEdit(interaction *discordgo.Interaction, messageID string, data *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	UserChannelCreate(recipientID string, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	ChannelMessageSend(channelID string, content string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessages(channelID string, limit int, beforeID, afterID, aroundID string, options ...discordgo.RequestOption) ([]*discordgo.Message, error)
+	ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	MessageThreadStart(channelID, messageID string, name string, archiveDuration int, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	UserChannelPermissions(userID, channelID string, fetchOptions ...discordgo.RequestOption) (int64, error)
+	GuildMemberRoleAdd(guildID, userID, roleID string, options ...discordgo.RequestOption) error
+	GuildMemberRoleRemove(guildID, userID, roleID string, options ...discordgo.RequestOption) error
+}
+
+var _ botSession = (*discordgo.Session)(nil)
+
+// timeoutMessage is what users see when a command runs out of time
+const timeoutMessage = "That took too long so I gave up, sorry. Try again in a bit"
+
+// deferResponse acknowledges an interaction straight away, for handlers that can't answer
+// within Discord's three seconds. Discord shows the user that the bot is thinking until the
+// handler answers with editResponse
+func deferResponse(s botSession, i *discordgo.InteractionCreate) error {
+	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+}
+
+// editResponse replaces what a deferred interaction is showing, either with progress or the
+// final answer. Once the interaction's context has run out, it says so instead
+func editResponse(ctx context.Context, s botSession, i *discordgo.InteractionCreate, content string) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		content = timeoutMessage
+	}
+	_, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content})
+	return err
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// fakeSession records everything the handlers try to do to Discord instead of doing it
+type fakeSession struct {
+	mu        sync.Mutex
+	responses []*discordgo.InteractionResponse
+	followups []*discordgo.WebhookParams
+	edits     []*discordgo.WebhookEdit
+	// responseEdits are the contents the original response was edited to, and responseEditData
+	// the whole edits
+	responseEdits    []string
+	responseEditData []*discordgo.WebhookEdit
+	messages         map[string][]string
+	// sent has the full message for everything in messages that had more than content
+	sent []*discordgo.MessageSend
+	// history has every message sent to each channel, newest first like Discord gives them
+	history      map[string][]*discordgo.Message
+	rolesAdded   []string
+	rolesRemoved []string
+	// threads are the names of threads started, keyed by the message they were started from
+	threads map[string]string
+	nextID  int
+	// noDMs makes opening a DM with a user fail, and failing makes sending to a channel fail
+	noDMs   map[string]error
+	failing map[string]error
+	// channelPermissions are what everyone can do in each channel, or everything if it's missing
+	channelPermissions map[string]int64
+}
+
+func newFakeSession() *fakeSession {
+	return &fakeSession{messages: map[string][]string{}, history: map[string][]*discordgo.Message{}, threads: map[string]string{}}
+}
+
+func (f *fakeSession) id() string {
+	f.nextID++
+	return strconv.Itoa(f.nextID)
+}
+
+func (f *fakeSession) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses = append(f.responses, resp)
+	return nil
+}
+
+func (f *fakeSession) InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	content := ""
+	if newresp.Content != nil {
+		content = *newresp.Content
+	}
+	f.responseEdits = append(f.responseEdits, content)
+	f.responseEditData = append(f.responseEditData, newresp)
+	return &discordgo.Message{ID: f.id(), ChannelID: interaction.ChannelID, Content: content}, nil
+}
+
+func (f *fakeSession) FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.followups = append(f.followups, data)
+	return &discordgo.Message{ID: f.id(), ChannelID: interaction.ChannelID, Content: data.Content}, nil
+}
+
+func (f *fakeSession) FollowupMessageEdit(interaction *discordgo.Interaction, messageID string, data *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.edits = append(f.edits, data)
+	return &discordgo.Message{ID: messageID, ChannelID: interaction.ChannelID}, nil
+}
+
+// UserChannelCreate gives every user a DM channel with the ID "dm-<user ID>"
+func (f *fakeSession) UserChannelCreate(recipientID string, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	if err := f.noDMs[recipientID]; err != nil {
+		return nil, err
+	}
+	return &discordgo.Channel{ID: "dm-" + recipientID, Type: discordgo.ChannelTypeDM}, nil
+}
+
+func (f *fakeSession) ChannelMessageSend(channelID string, content string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages[channelID] = append(f.messages[channelID], content)
+	return &discordgo.Message{ID: f.id(), ChannelID: channelID, Content: content}, nil
+}
+
+func (f *fakeSession) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failing[channelID]; err != nil {
+		return nil, err
+	}
+	f.messages[channelID] = append(f.messages[channelID], data.Content)
+	f.sent = append(f.sent, data)
+	msg := &discordgo.Message{ID: f.id(), ChannelID: channelID, Content: data.Content, Components: data.Components, Timestamp: time.Now()}
+	f.history[channelID] = append([]*discordgo.Message{msg}, f.history[channelID]...)
+	return msg, nil
+}
+
+func (f *fakeSession) ChannelMessages(channelID string, limit int, beforeID, afterID, aroundID string, options ...discordgo.RequestOption) ([]*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	msgs := f.history[channelID]
+	if len(msgs) > limit {
+		msgs = msgs[:limit]
+	}
+	return msgs, nil
+}
+
+// MessageThreadStart gives a thread started from a message the ID "thread-<message ID>"
+func (f *fakeSession) MessageThreadStart(channelID, messageID string, name string, archiveDuration int, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.threads[messageID] = name
+	return &discordgo.Channel{ID: "thread-" + messageID, ParentID: channelID, Name: name, Type: discordgo.ChannelTypeGuildPublicThread}, nil
+}
+
+func (f *fakeSession) GuildMemberRoleAdd(guildID, userID, roleID string, options ...discordgo.RequestOption) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rolesAdded = append(f.rolesAdded, roleID)
+	return nil
+}
+
+func (f *fakeSession) GuildMemberRoleRemove(guildID, userID, roleID string, options ...discordgo.RequestOption) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rolesRemoved = append(f.rolesRemoved, roleID)
+	return nil
+}
+
+func (f *fakeSession) UserChannelPermissions(userID, channelID string, fetchOptions ...discordgo.RequestOption) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if perms, ok := f.channelPermissions[channelID]; ok {
+		return perms, nil
+	}
+	return discordgo.PermissionAll, nil
+}
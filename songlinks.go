@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// songLink is a song someone's linked, pinned down to one provider's ID for it so the same
+// song posted as different URLs is recognised as the same
+type songLink struct {
+	Provider string
+	// ID is the provider's ID for the song. For Bandcamp and SoundCloud, which don't show IDs
+	// in links, it's the artist and track names from the path
+	ID string
+	// URL is the tidiest link to the song, without tracking or timestamps
+	URL string
+}
+
+// Providers songs can be linked from
+const (
+	providerYouTube    = "YouTube"
+	providerSpotify    = "Spotify"
+	providerBandcamp   = "Bandcamp"
+	providerSoundCloud = "SoundCloud"
+	providerApple      = "Apple Music"
+)
+
+var (
+	youtubeID   = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+	spotifyID   = regexp.MustCompile(`^[A-Za-z0-9]{22}$`)
+	appleID     = regexp.MustCompile(`^\d+$`)
+	pathSegment = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	// countryCode is the storefront at the start of Apple Music paths, and localeCode the
+	// language Spotify sometimes puts at the start of its paths
+	countryCode = regexp.MustCompile(`^[a-z]{2}$`)
+	localeCode  = regexp.MustCompile(`^intl-[a-z]{2}(-[a-z]{2})?$`)
+)
+
+// soundCloudPages are the first parts of SoundCloud paths that aren't artists
+var soundCloudPages = []string{"discover", "search", "stream", "upload", "you", "charts", "pages", "tags", "stations", "settings", "messages", "notifications", "people", "mobile", "terms-of-use", "jobs"}
+
+// errNotASong is returned for links to things on a provider that aren't a single song
+var errNotASong = errors.New("that links to something other than a single song")
+
+// parseSongLink works out which song a link is to, so long as it's on a provider the bot knows
+func parseSongLink(raw string) (songLink, error) {
+	raw = strings.TrimSpace(raw)
+	// Discord users wrap links in <> to stop them embedding
+	raw = strings.TrimSuffix(strings.TrimPrefix(raw, "<"), ">")
+	if strings.HasPrefix(raw, "spotify:") {
+		return spotifyURI(raw)
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || strings.ContainsAny(u.Host, " \t") {
+		return songLink{}, errors.New("that isn't a link")
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if !strings.Contains(host, ".") {
+		return songLink{}, errors.New("that isn't a link")
+	}
+	var path []string
+	for _, part := range strings.Split(u.Path, "/") {
+		if part != "" {
+			path = append(path, part)
+		}
+	}
+
+	switch {
+	case host == "youtu.be":
+		if len(path) != 1 {
+			return songLink{}, errors.New("that YouTube link doesn't have a video in it")
+		}
+		return youtubeLink(path[0])
+	case host == "youtube.com" || host == "m.youtube.com" || host == "music.youtube.com" || host == "youtube-nocookie.com":
+		return youtubeURL(u, path)
+	case host == "open.spotify.com" || host == "play.spotify.com":
+		if len(path) > 0 && localeCode.MatchString(path[0]) {
+			path = path[1:]
+		}
+		if len(path) != 2 {
+			return songLink{}, errNotASong
+		}
+		return spotifyItem(path[0], path[1])
+	case strings.HasSuffix(host, ".bandcamp.com"):
+		artist := strings.TrimSuffix(host, ".bandcamp.com")
+		if !pathSegment.MatchString(artist) || len(path) != 2 || path[0] != "track" || !pathSegment.MatchString(path[1]) {
+			return songLink{}, errNotASong
+		}
+		return songLink{Provider: providerBandcamp, ID: artist + "/" + path[1], URL: "https://" + artist + ".bandcamp.com/track/" + path[1]}, nil
+	case host == "soundcloud.com" || host == "m.soundcloud.com":
+		if len(path) != 2 || contains(soundCloudPages, path[0]) || path[1] == "sets" || !pathSegment.MatchString(path[0]) || !pathSegment.MatchString(path[1]) {
+			return songLink{}, errNotASong
+		}
+		id := strings.ToLower(path[0] + "/" + path[1])
+		return songLink{Provider: providerSoundCloud, ID: id, URL: "https://soundcloud.com/" + id}, nil
+	case host == "on.soundcloud.com":
+		// Short links can't be followed without going online, so they're their own ID
+		if len(path) != 1 || !pathSegment.MatchString(path[0]) {
+			return songLink{}, errNotASong
+		}
+		return songLink{Provider: providerSoundCloud, ID: "on/" + path[0], URL: "https://on.soundcloud.com/" + path[0]}, nil
+	case host == "music.apple.com" || host == "itunes.apple.com":
+		return appleURL(u, path)
+	}
+	return songLink{}, fmt.Errorf("I don't know songs from %v, so link to it on YouTube, Spotify, Bandcamp, SoundCloud or Apple Music", u.Hostname())
+}
+
+func youtubeLink(id string) (songLink, error) {
+	if !youtubeID.MatchString(id) {
+		return songLink{}, errors.New("that YouTube link's video ID isn't right")
+	}
+	return songLink{Provider: providerYouTube, ID: id, URL: "https://www.youtube.com/watch?v=" + id}, nil
+}
+
+func youtubeURL(u *url.URL, path []string) (songLink, error) {
+	if len(path) == 1 && path[0] == "watch" {
+		if v := u.Query().Get("v"); v != "" {
+			return youtubeLink(v)
+		}
+		return songLink{}, errors.New("that YouTube link doesn't have a video in it")
+	}
+	if len(path) == 2 {
+		switch path[0] {
+		case "shorts", "embed", "live", "v", "e":
+			return youtubeLink(path[1])
+		}
+	}
+	if len(path) == 1 && path[0] == "playlist" {
+		return songLink{}, errors.New("that's a playlist, so pick a song from it")
+	}
+	return songLink{}, errNotASong
+}
+
+// spotifyURI reads a spotify:track:ID link, as the desktop app copies them
+func spotifyURI(raw string) (songLink, error) {
+	parts := strings.Split(raw, ":")
+	if len(parts) != 3 {
+		return songLink{}, errors.New("that isn't a Spotify link")
+	}
+	return spotifyItem(parts[1], parts[2])
+}
+
+func spotifyItem(kind, id string) (songLink, error) {
+	if kind != "track" {
+		return songLink{}, errNotASong
+	}
+	if !spotifyID.MatchString(id) {
+		return songLink{}, errors.New("that Spotify link's track ID isn't right")
+	}
+	return songLink{Provider: providerSpotify, ID: id, URL: "https://open.spotify.com/track/" + id}, nil
+}
+
+// appleURL reads Apple Music song links, and album links that pick out a song with ?i=
+func appleURL(u *url.URL, path []string) (songLink, error) {
+	country := "us"
+	if len(path) > 0 && countryCode.MatchString(path[0]) {
+		country, path = path[0], path[1:]
+	}
+	var id string
+	switch {
+	case len(path) >= 2 && path[0] == "song":
+		id = path[len(path)-1]
+	case len(path) >= 2 && path[0] == "album":
+		id = u.Query().Get("i")
+		if id == "" {
+			return songLink{}, errors.New("that's an album, so pick a song from it")
+		}
+	default:
+		return songLink{}, errNotASong
+	}
+	id = strings.TrimPrefix(id, "id")
+	if !appleID.MatchString(id) {
+		return songLink{}, errors.New("that Apple Music link's song ID isn't right")
+	}
+	return songLink{Provider: providerApple, ID: id, URL: "https://music.apple.com/" + country + "/song/" + id}, nil
+}
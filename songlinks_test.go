@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSongLink(t *testing.T) {
+	const (
+		video   = "dQw4w9WgXcQ"
+		track   = "4cOdK2wGLETKBW3PvgPWqT"
+		watch   = "https://www.youtube.com/watch?v=" + video
+		spotify = "https://open.spotify.com/track/" + track
+	)
+	tests := []struct {
+		link     string
+		provider string
+		id       string
+		url      string
+	}{
+		// YouTube, in every shape people paste it
+		{"https://www.youtube.com/watch?v=" + video, providerYouTube, video, watch},
+		{"https://youtube.com/watch?v=" + video, providerYouTube, video, watch},
+		{"http://www.youtube.com/watch?v=" + video, providerYouTube, video, watch},
+		{"www.youtube.com/watch?v=" + video, providerYouTube, video, watch},
+		{"youtube.com/watch?v=" + video, providerYouTube, video, watch},
+		{"https://m.youtube.com/watch?v=" + video, providerYouTube, video, watch},
+		{"https://music.youtube.com/watch?v=" + video + "&feature=share", providerYouTube, video, watch},
+		{"https://www.youtube.com/watch?feature=youtu.be&v=" + video, providerYouTube, video, watch},
+		{"https://www.youtube.com/watch?list=PL1234&index=3&v=" + video + "&t=42s", providerYouTube, video, watch},
+		{"https://WWW.YouTube.com/watch?v=" + video, providerYouTube, video, watch},
+		{"https://youtu.be/" + video, providerYouTube, video, watch},
+		{"https://youtu.be/" + video + "?t=30", providerYouTube, video, watch},
+		{"https://youtu.be/" + video + "?si=abcdef", providerYouTube, video, watch},
+		{"youtu.be/" + video, providerYouTube, video, watch},
+		{"<https://youtu.be/" + video + ">", providerYouTube, video, watch},
+		{"  https://youtu.be/" + video + "  ", providerYouTube, video, watch},
+		{"https://www.youtube.com/shorts/" + video, providerYouTube, video, watch},
+		{"https://youtube.com/shorts/" + video + "?feature=share", providerYouTube, video, watch},
+		{"https://www.youtube.com/embed/" + video, providerYouTube, video, watch},
+		{"https://www.youtube.com/embed/" + video + "?start=10", providerYouTube, video, watch},
+		{"https://www.youtube-nocookie.com/embed/" + video, providerYouTube, video, watch},
+		{"https://www.youtube.com/live/" + video + "?si=x", providerYouTube, video, watch},
+		{"https://www.youtube.com/v/" + video, providerYouTube, video, watch},
+		{"https://www.youtube.com/watch/?v=" + video, providerYouTube, video, watch},
+		{"https://youtu.be/a-b_c-d_e-f", providerYouTube, "a-b_c-d_e-f", "https://www.youtube.com/watch?v=a-b_c-d_e-f"},
+
+		// Spotify
+		{spotify, providerSpotify, track, spotify},
+		{spotify + "?si=1a2b3c4d5e", providerSpotify, track, spotify},
+		{"https://open.spotify.com/intl-de/track/" + track, providerSpotify, track, spotify},
+		{"https://open.spotify.com/intl-pt-br/track/" + track + "?si=x", providerSpotify, track, spotify},
+		{"open.spotify.com/track/" + track, providerSpotify, track, spotify},
+		{"https://play.spotify.com/track/" + track, providerSpotify, track, spotify},
+		{"spotify:track:" + track, providerSpotify, track, spotify},
+
+		// Bandcamp
+		{"https://grantkirkhope.bandcamp.com/track/spiral-mountain", providerBandcamp, "grantkirkhope/spiral-mountain", "https://grantkirkhope.bandcamp.com/track/spiral-mountain"},
+		{"https://GrantKirkhope.bandcamp.com/track/spiral-mountain?from=search", providerBandcamp, "grantkirkhope/spiral-mountain", "https://grantkirkhope.bandcamp.com/track/spiral-mountain"},
+		{"http://grant-kirkhope.bandcamp.com/track/spiral_mountain-2/", providerBandcamp, "grant-kirkhope/spiral_mountain-2", "https://grant-kirkhope.bandcamp.com/track/spiral_mountain-2"},
+
+		// SoundCloud
+		{"https://soundcloud.com/rare-ltd/mumbos-mountain", providerSoundCloud, "rare-ltd/mumbos-mountain", "https://soundcloud.com/rare-ltd/mumbos-mountain"},
+		{"https://soundcloud.com/Rare-Ltd/Mumbos-Mountain?in=someone/sets/n64", providerSoundCloud, "rare-ltd/mumbos-mountain", "https://soundcloud.com/rare-ltd/mumbos-mountain"},
+		{"https://m.soundcloud.com/rare-ltd/mumbos-mountain", providerSoundCloud, "rare-ltd/mumbos-mountain", "https://soundcloud.com/rare-ltd/mumbos-mountain"},
+		{"https://www.soundcloud.com/rare-ltd/mumbos-mountain/", providerSoundCloud, "rare-ltd/mumbos-mountain", "https://soundcloud.com/rare-ltd/mumbos-mountain"},
+		{"https://on.soundcloud.com/AbCdE12345", providerSoundCloud, "on/AbCdE12345", "https://on.soundcloud.com/AbCdE12345"},
+
+		// Apple Music
+		{"https://music.apple.com/gb/album/banjo-kazooie/1440857781?i=1440858131", providerApple, "1440858131", "https://music.apple.com/gb/song/1440858131"},
+		{"https://music.apple.com/us/album/1440857781?i=1440858131&l=es", providerApple, "1440858131", "https://music.apple.com/us/song/1440858131"},
+		{"https://music.apple.com/gb/song/spiral-mountain/1440858131", providerApple, "1440858131", "https://music.apple.com/gb/song/1440858131"},
+		{"https://music.apple.com/song/1440858131", providerApple, "1440858131", "https://music.apple.com/us/song/1440858131"},
+		{"https://itunes.apple.com/gb/album/banjo-kazooie/id1440857781?i=1440858131", providerApple, "1440858131", "https://music.apple.com/gb/song/1440858131"},
+		{"https://music.apple.com/gb/song/spiral-mountain/id1440858131", providerApple, "1440858131", "https://music.apple.com/gb/song/1440858131"},
+	}
+	for _, test := range tests {
+		got, err := parseSongLink(test.link)
+		if err != nil {
+			t.Errorf("%q: %v", test.link, err)
+			continue
+		}
+		if got.Provider != test.provider || got.ID != test.id || got.URL != test.url {
+			t.Errorf("%q gave %+v, want %v %v %v", test.link, got, test.provider, test.id, test.url)
+		}
+		// The tidied link should come back as itself
+		if again, err := parseSongLink(got.URL); err != nil || again != got {
+			t.Errorf("%q tidied to %q, which gave %+v, %v", test.link, got.URL, again, err)
+		}
+	}
+}
+
+func TestParseSongLinkRejects(t *testing.T) {
+	tests := []struct {
+		link string
+		want string
+	}{
+		{"", "isn't a link"},
+		{"Spiral Mountain by Grant Kirkhope", "isn't a link"},
+		{"banjo", "isn't a link"},
+		{"ftp://youtube.com/watch?v=dQw4w9WgXcQ", "isn't a link"},
+		{"https://", "isn't a link"},
+		{"https://youtube.com/watch?v=short", "video ID isn't right"},
+		{"https://youtube.com/watch?v=dQw4w9WgXcQextra", "video ID isn't right"},
+		{"https://youtube.com/watch?v=dQw4w9WgX<Q", "video ID isn't right"},
+		{"https://youtube.com/watch?list=PL1234", "doesn't have a video"},
+		{"https://youtube.com/watch", "doesn't have a video"},
+		{"https://youtu.be/", "doesn't have a video"},
+		{"https://youtu.be/dQw4w9WgXcQ/extra", "doesn't have a video"},
+		{"https://youtube.com/playlist?list=PL1234", "that's a playlist"},
+		{"https://youtube.com/@grantkirkhope", "something other than a single song"},
+		{"https://youtube.com/channel/UC1234", "something other than a single song"},
+		{"https://youtube.com/", "something other than a single song"},
+		{"https://open.spotify.com/album/4cOdK2wGLETKBW3PvgPWqT", "something other than a single song"},
+		{"https://open.spotify.com/playlist/4cOdK2wGLETKBW3PvgPWqT", "something other than a single song"},
+		{"https://open.spotify.com/artist/4cOdK2wGLETKBW3PvgPWqT", "something other than a single song"},
+		{"https://open.spotify.com/track/tooshort", "track ID isn't right"},
+		{"https://open.spotify.com/track/", "something other than a single song"},
+		{"spotify:album:4cOdK2wGLETKBW3PvgPWqT", "something other than a single song"},
+		{"spotify:track", "isn't a Spotify link"},
+		{"https://grantkirkhope.bandcamp.com/album/banjo-kazooie", "something other than a single song"},
+		{"https://grantkirkhope.bandcamp.com/", "something other than a single song"},
+		{"https://bandcamp.com/track/spiral-mountain", "I don't know songs from bandcamp.com"},
+		{"https://soundcloud.com/rare-ltd", "something other than a single song"},
+		{"https://soundcloud.com/rare-ltd/sets/n64", "something other than a single song"},
+		{"https://soundcloud.com/discover/sets", "something other than a single song"},
+		{"https://soundcloud.com/search/sounds", "something other than a single song"},
+		{"https://music.apple.com/gb/album/banjo-kazooie/1440857781", "that's an album"},
+		{"https://music.apple.com/gb/artist/grant-kirkhope/123", "something other than a single song"},
+		{"https://music.apple.com/gb/song/spiral-mountain/abc", "song ID isn't right"},
+		{"https://music.apple.com/gb/album/banjo-kazooie/1440857781?i=abc", "song ID isn't right"},
+		{"https://tidal.com/browse/track/12345", "I don't know songs from tidal.com"},
+		{"https://notyoutube.com/watch?v=dQw4w9WgXcQ", "I don't know songs from notyoutube.com"},
+		{"https://youtube.com.evil.example/watch?v=dQw4w9WgXcQ", "I don't know songs from"},
+	}
+	for _, test := range tests {
+		got, err := parseSongLink(test.link)
+		if err == nil {
+			t.Errorf("%q gave %+v, want an error", test.link, got)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%q gave %q, want it to mention %q", test.link, err, test.want)
+		}
+	}
+}
+
+func TestMusicSavesTidiedLinks(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	db.AddMonth(ctx, month{GuildID: "1", StartTime: time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), Days: []day{{Day: 1, Prompt: "birds"}}})
+
+	fake := newFakeSession()
+	handleInteraction(ctx, fake, slashCommand("music", stringOption("song", "https://tidal.com/browse/track/12345"), intOption("day", 1)))
+	if len(fake.responses) != 1 || !strings.HasPrefix(fake.responses[0].Data.Content, "I didn't save that: I don't know songs from tidal.com") {
+		t.Fatalf("Got responses %+v, want the link refused", fake.responses)
+	}
+
+	fake = newFakeSession()
+	handleInteraction(ctx, fake, slashCommand("music", stringOption("song", "https://youtu.be/dQw4w9WgXcQ?si=tracking"), intOption("day", 1)))
+	if len(fake.responseEdits) != 1 || fake.responseEdits[0] != "Submitting https://www.youtube.com/watch?v=dQw4w9WgXcQ for day 1" {
+		t.Fatalf("Got edits %q, want the tidied link submitted", fake.responseEdits)
+	}
+	songs, err := db.Songs(ctx, "1", now.Format("Jan 2006"), "", 0)
+	if err != nil || len(songs) != 1 || songs[0].Song != "https://www.youtube.com/watch?v=dQw4w9WgXcQ" {
+		t.Errorf("Got songs %+v, %v, want the tidied link saved", songs, err)
+	}
+}
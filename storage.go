@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// errNotFound is returned by a store when a lookup matches nothing
+var errNotFound = errors.New("not found")
+
+// errNotClaimed and errLeaseLost are returned when a reminder or playlist job can't be
+// claimed, or has been claimed by someone else since
+var (
+	errNotClaimed = errors.New("not due or being handled by someone else")
+	errLeaseLost  = errors.New("lost the claim")
+	// errClaimed is returned when changing a reminder that's being delivered, and errChanged
+	// when it's been delivered since it was read
+	errClaimed = errors.New("being delivered")
+	errChanged = errors.New("changed since it was read")
+)
+
+type reminder struct {
+	ID       string    `firestore:"-" json:"-"`
+	GuildID  string    `firestore:"guildID" json:"guildID"`
+	UserID   string    `firestore:"userID" json:"userID"`
+	Reminder string    `firestore:"reminder" json:"reminder"`
+	Date     time.Time `firestore:"date" json:"date"`
+	// Repeat is the cron schedule a reminder goes off on after Date, or empty for a one-off
+	Repeat string `firestore:"repeat" json:"repeat,omitempty"`
+	// Until and Times stop a repeating reminder after a time or a number of deliveries; zero
+	// means keep going. Sent counts deliveries so far
+	Until time.Time `firestore:"until" json:"until"`
+	Times int       `firestore:"times" json:"times,omitempty"`
+	Sent  int       `firestore:"sent" json:"sent,omitempty"`
+	// Snoozed is set when a reminder's been pushed back from its message, so that delivery
+	// doesn't count towards Times. Delivered is set once a reminder has gone off for the last
+	// time; it's kept a while so it can still be snoozed
+	Snoozed   bool `firestore:"snoozed" json:"snoozed,omitempty"`
+	Delivered bool `firestore:"delivered" json:"delivered,omitempty"`
+	// ClaimedBy is the instance delivering a reminder, which has it until LeaseUntil. If the
+	// lease runs out the message may or may not have been sent, so the next claimant checks
+	ClaimedBy  string    `firestore:"claimedBy" json:"claimedBy,omitempty"`
+	LeaseUntil time.Time `firestore:"leaseUntil" json:"leaseUntil"`
+	// MessageID is the last message the reminder was delivered in
+	MessageID string `firestore:"messageID" json:"messageID,omitempty"`
+	// ChannelID is where the reminder was set, so it can be delivered there if DMs don't work
+	ChannelID string `firestore:"channelID" json:"channelID,omitempty"`
+	// PostChannelID is set for announcements, which are posted there rather than DMed, pinging
+	// PingRoleID or PingUserID if either's set
+	PostChannelID string `firestore:"postChannelID" json:"postChannelID,omitempty"`
+	PingRoleID    string `firestore:"pingRoleID" json:"pingRoleID,omitempty"`
+	PingUserID    string `firestore:"pingUserID" json:"pingUserID,omitempty"`
+	// AboutMessageID is the message in ChannelID a reminder was set from with the message menu,
+	// and Quote is what it said at the time
+	AboutMessageID string `firestore:"aboutMessageID" json:"aboutMessageID,omitempty"`
+	Quote          string `firestore:"quote" json:"quote,omitempty"`
+	// Attempts counts failed tries at delivering the reminder this time round, and RetryAt is
+	// when to try again. Failure is what went wrong last, and Dead is set once it's given up on
+	Attempts int       `firestore:"attempts" json:"attempts,omitempty"`
+	RetryAt  time.Time `firestore:"retryAt" json:"retryAt"`
+	Failure  string    `firestore:"failure" json:"failure,omitempty"`
+	Dead     bool      `firestore:"dead" json:"dead,omitempty"`
+	// Version goes up with every delivery or change, so a change can tell what it read is stale
+	Version int `firestore:"version" json:"version,omitempty"`
+}
+
+// dueAt is when delivery should next be tried, allowing for retries
+func (r reminder) dueAt() time.Time {
+	if r.RetryAt.After(r.Date) {
+		return r.RetryAt
+	}
+	return r.Date
+}
+
+// claimable says whether a reminder can be claimed for delivery at the given time
+func (r reminder) claimable(owner string, now time.Time) bool {
+	if r.Delivered || r.Dead || r.dueAt().After(now) {
+		return false
+	}
+	return r.ClaimedBy == "" || r.ClaimedBy == owner || !r.LeaseUntil.After(now)
+}
+
+// claimed says whether some instance is delivering a reminder at the given time
+func (r reminder) claimed(now time.Time) bool {
+	return r.ClaimedBy != "" && r.LeaseUntil.After(now)
+}
+
+// calendarFeed lets a calendar app fetch someone's reminders and music prompts for a guild.
+// The ID is a hash of the token in the feed's URL, so the store alone can't be used to read feeds
+type calendarFeed struct {
+	ID      string `firestore:"-" json:"-"`
+	GuildID string `firestore:"guildID" json:"guildID"`
+	UserID  string `firestore:"userID" json:"userID"`
+}
+
+// monthChange records an organiser changing a music month after it was set up. Month is the
+// month's name, like Jan 2006, at the time
+type monthChange struct {
+	ID      string    `firestore:"-" json:"-"`
+	GuildID string    `firestore:"guildID" json:"guildID"`
+	Month   string    `firestore:"month" json:"month"`
+	UserID  string    `firestore:"userID" json:"userID"`
+	Time    time.Time `firestore:"time" json:"time"`
+	Change  string    `firestore:"change" json:"change"`
+}
+
+type song struct {
+	ID      string `firestore:"-" json:"-"`
+	GuildID string `firestore:"guildID" json:"guildID"`
+	UserID  string `firestore:"userID" json:"userID"`
+	Month   string `firestore:"month" json:"month"`
+	Day     int    `firestore:"day" json:"day"`
+	Song    string `firestore:"song" json:"song"`
+}
+
+type playlist struct {
+	GuildID    string `firestore:"guildID" json:"guildID"`
+	UserID     string `firestore:"userID" json:"userID"`
+	Month      string `firestore:"month" json:"month"`
+	Day        int    `firestore:"day" json:"day"`
+	PlaylistID string `firestore:"playlistID" json:"playlistID"`
+}
+
+// Playlist job statuses. Queued and running jobs are pending; finished ones are kept until
+// the same playlist's asked for again
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// playlistWaiter is someone who asked for a playlist, whose answer is edited once it's built.
+// AppID and Token are from their interaction, which Discord lets the bot edit for 15 minutes
+type playlistWaiter struct {
+	AppID     string `firestore:"appID" json:"appID"`
+	Token     string `firestore:"token" json:"token"`
+	ChannelID string `firestore:"channelID" json:"channelID"`
+	UserID    string `firestore:"userID" json:"userID"`
+}
+
+// playlistJob is a YouTube playlist waiting to be built or brought up to date. Its ID comes
+// from the playlist, so asking for one that's already queued joins the job rather than adding another
+type playlistJob struct {
+	ID      string `firestore:"-" json:"-"`
+	GuildID string `firestore:"guildID" json:"guildID"`
+	Month   string `firestore:"month" json:"month"`
+	// UserID and Username are set for someone's own playlist, and Day for a single day's
+	UserID   string           `firestore:"userID" json:"userID"`
+	Username string           `firestore:"username" json:"username"`
+	Day      int              `firestore:"day" json:"day"`
+	Status   string           `firestore:"status" json:"status"`
+	Queued   time.Time        `firestore:"queued" json:"queued"`
+	Waiters  []playlistWaiter `firestore:"waiters" json:"waiters"`
+	// Result is what the waiters were told once it finished
+	Result string `firestore:"result" json:"result,omitempty"`
+	// ClaimedBy is the instance building the playlist, which has it until LeaseUntil
+	ClaimedBy  string    `firestore:"claimedBy" json:"claimedBy,omitempty"`
+	LeaseUntil time.Time `firestore:"leaseUntil" json:"leaseUntil"`
+}
+
+func playlistJobID(guildID, monthName, userID string, day int) string {
+	return fmt.Sprintf("%v-%v-%v-%d", guildID, strings.ReplaceAll(monthName, " ", ""), userID, day)
+}
+
+func (j playlistJob) pending() bool {
+	return j.Status == jobQueued || j.Status == jobRunning
+}
+
+// claimable says whether a job can be claimed to run at the given time. A running job whose
+// lease has run out was left by an instance that stopped, so it's started again
+func (j playlistJob) claimable(owner string, now time.Time) bool {
+	if !j.pending() {
+		return false
+	}
+	return j.ClaimedBy == "" || j.ClaimedBy == owner || !j.LeaseUntil.After(now)
+}
+
+// grant gives a capability to everyone with a role, or to a single user
+type grant struct {
+	ID         string `firestore:"-" json:"-"`
+	GuildID    string `firestore:"guildID" json:"guildID"`
+	Capability string `firestore:"capability" json:"capability"`
+	RoleID     string `firestore:"roleID" json:"roleID"`
+	UserID     string `firestore:"userID" json:"userID"`
+}
+
+// store is everything the bot needs to remember between restarts. Implementations
+// live in storage_firestore.go (GCP) and storage_bolt.go (a local file). Everything
+// apart from reminder delivery is looked up per guild, so servers never see each other's data
+type store interface {
+	// AddReminder stores a new reminder, returning its ID
+	AddReminder(ctx context.Context, r reminder) (string, error)
+	// DueReminders gets every reminder due before the given time, including delivered ones but
+	// not dead ones
+	DueReminders(ctx context.Context, before time.Time) ([]reminder, error)
+	// UndeliveredReminders gets a guild's dead reminders, which couldn't be delivered at all
+	UndeliveredReminders(ctx context.Context, guildID string) ([]reminder, error)
+	// Reminder gets a reminder by ID, or errNotFound
+	Reminder(ctx context.Context, id string) (*reminder, error)
+	// UserReminders gets someone's reminders in a guild, soonest first
+	UserReminders(ctx context.Context, guildID, userID string) ([]reminder, error)
+	// UpdateReminder replaces the reminder with the same ID
+	UpdateReminder(ctx context.Context, r reminder) error
+	// ClaimReminder takes a due reminder for owner to deliver until leaseUntil, returning it as
+	// it was before the claim, or errNotClaimed. It's atomic, so only one instance gets it
+	ClaimReminder(ctx context.Context, id, owner string, now, leaseUntil time.Time) (*reminder, error)
+	// CompleteReminder saves a reminder owner has delivered and drops the claim, or returns
+	// errLeaseLost without saving if owner doesn't hold the claim any more
+	CompleteReminder(ctx context.Context, r reminder, owner string) error
+	DeleteReminder(ctx context.Context, id string) error
+	// ChangeReminder replaces the reminder with the same ID, or returns errClaimed without
+	// saving if it's being delivered at the given time, or errChanged if r's Version is stale.
+	// It's atomic, so it can't race a delivery
+	ChangeReminder(ctx context.Context, r reminder, now time.Time) error
+	// CancelReminder deletes a reminder, or returns errClaimed if it's being delivered at the given time
+	CancelReminder(ctx context.Context, id string, now time.Time) error
+
+	AddMonth(ctx context.Context, m month) error
+	// NextMonth gets the earliest music month starting after the given time
+	NextMonth(ctx context.Context, guildID string, after time.Time) (*month, error)
+	// LatestMonth gets the most recent music month starting before the given time
+	LatestMonth(ctx context.Context, guildID string, before time.Time) (*month, error)
+	// UpdateMonth replaces the month with the same ID
+	UpdateMonth(ctx context.Context, m month) error
+	DeleteMonth(ctx context.Context, id string) error
+	AddMonthChange(ctx context.Context, c monthChange) error
+	// MonthChanges gets every change made to a guild's months, newest first
+	MonthChanges(ctx context.Context, guildID string) ([]monthChange, error)
+
+	// Songs gets the picks for a month; an empty userID means everyone's and a zero day means every day
+	Songs(ctx context.Context, guildID, monthName, userID string, day int) ([]song, error)
+	// SaveSong stores a pick, returning the pick it replaced if there was one
+	SaveSong(ctx context.Context, s song) (*song, error)
+
+	Playlist(ctx context.Context, guildID, monthName, userID string, day int) (*playlist, error)
+	AddPlaylist(ctx context.Context, p playlist) error
+	// QueuePlaylistJob queues a job, or if one for the same playlist is pending adds the new
+	// job's waiters to that instead. It returns the job as it's stored
+	QueuePlaylistJob(ctx context.Context, j playlistJob) (*playlistJob, error)
+	// PendingPlaylistJobs gets every queued or running job, oldest first
+	PendingPlaylistJobs(ctx context.Context) ([]playlistJob, error)
+	// ClaimPlaylistJob takes a pending job for owner to run until leaseUntil and marks it
+	// running, returning it or errNotClaimed. It's atomic, so only one instance gets it
+	ClaimPlaylistJob(ctx context.Context, id, owner string, now, leaseUntil time.Time) (*playlistJob, error)
+	// FinishPlaylistJob saves how a job owner ran went and drops the claim, returning it with
+	// everyone waiting on it, or errLeaseLost if owner doesn't hold the claim any more
+	FinishPlaylistJob(ctx context.Context, id, owner, status, result string) (*playlistJob, error)
+
+	// AddGrant stores a grant, doing nothing if an identical one exists
+	AddGrant(ctx context.Context, g grant) error
+	// RemoveGrant removes a grant matching everything but the ID, or returns errNotFound
+	RemoveGrant(ctx context.Context, g grant) error
+	Grants(ctx context.Context, guildID string) ([]grant, error)
+
+	// Setting gets a bot-wide value saved with SaveSetting, or errNotFound
+	Setting(ctx context.Context, name string) ([]byte, error)
+	SaveSetting(ctx context.Context, name string, value []byte) error
+	// SwapSetting saves value only if the setting still holds old, which is nil for one that was
+	// never saved, or returns errNotClaimed. It's atomic, so only one instance gets to swap it
+	SwapSetting(ctx context.Context, name string, old, value []byte) error
+
+	// UserTimezone gets the IANA zone a user has picked, or errNotFound. It's per user rather
+	// than per guild, since people take their clocks with them
+	UserTimezone(ctx context.Context, userID string) (string, error)
+	SaveUserTimezone(ctx context.Context, userID, zone string) error
+
+	// CalendarFeed gets a calendar feed by its ID, or errNotFound
+	CalendarFeed(ctx context.Context, id string) (*calendarFeed, error)
+	// SaveCalendarFeed stores a feed, replacing any other feed the same user has in the guild
+	SaveCalendarFeed(ctx context.Context, f calendarFeed) error
+
+	// TagGuild puts every record saved before the bot knew about guilds into the given guild,
+	// returning how many records it changed
+	TagGuild(ctx context.Context, guildID string) (int, error)
+
+	Close() error
+}
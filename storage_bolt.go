@@ -0,0 +1,713 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltStore keeps everything in a single local file, one bucket per Firestore collection,
+// with each record stored as JSON under a sequential ID
+type boltStore struct {
+	db *bolt.DB
+}
+
+// boltBuckets hold per-guild records; settings and timezones are kept apart since they aren't
+// per guild or JSON, and calendars, monthchanges and playlistjobs since they were never saved
+// without a guild
+var boltBuckets = []string{"reminders", "musicmonth", "music", "musicplaylists", "permissions"}
+
+func newBoltStore(path string) (*boltStore, error) {
+	// Don't hang forever if another copy of the bot has the file open
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range append(boltBuckets, "settings", "timezones", "calendars", "monthchanges", "playlistjobs") {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+// boltInsert stores a record under the next ID in the bucket, returning the ID
+func boltInsert(bucket *bolt.Bucket, v interface{}) (string, error) {
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	id := strconv.FormatUint(seq, 10)
+	return id, bucket.Put([]byte(id), data)
+}
+
+func (b *boltStore) add(bucket string, v interface{}) (string, error) {
+	var id string
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		id, err = boltInsert(tx.Bucket([]byte(bucket)), v)
+		return err
+	})
+	return id, err
+}
+
+// each calls fn with the ID and JSON of every record in a bucket
+func (b *boltStore) each(bucket string, fn func(id string, data []byte) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucket)).ForEach(func(k, v []byte) error {
+			return fn(string(k), v)
+		})
+	})
+}
+
+func (b *boltStore) AddReminder(ctx context.Context, r reminder) (string, error) {
+	return b.add("reminders", r)
+}
+
+func (b *boltStore) DueReminders(ctx context.Context, before time.Time) ([]reminder, error) {
+	var reminders []reminder
+	err := b.each("reminders", func(id string, data []byte) error {
+		var r reminder
+		if err := json.Unmarshal(data, &r); err != nil {
+			return err
+		}
+		if r.Date.Before(before) && !r.Dead {
+			r.ID = id
+			reminders = append(reminders, r)
+		}
+		return nil
+	})
+	return reminders, err
+}
+
+func (b *boltStore) UndeliveredReminders(ctx context.Context, guildID string) ([]reminder, error) {
+	var reminders []reminder
+	err := b.each("reminders", func(id string, data []byte) error {
+		var r reminder
+		if err := json.Unmarshal(data, &r); err != nil {
+			return err
+		}
+		if r.GuildID == guildID && r.Dead {
+			r.ID = id
+			reminders = append(reminders, r)
+		}
+		return nil
+	})
+	sort.Slice(reminders, func(a, b int) bool { return reminders[a].Date.Before(reminders[b].Date) })
+	return reminders, err
+}
+
+func (b *boltStore) Reminder(ctx context.Context, id string) (*reminder, error) {
+	var r *reminder
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte("reminders")).Get([]byte(id))
+		if data == nil {
+			return errNotFound
+		}
+		r = &reminder{}
+		if err := json.Unmarshal(data, r); err != nil {
+			return err
+		}
+		r.ID = id
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (b *boltStore) UserReminders(ctx context.Context, guildID, userID string) ([]reminder, error) {
+	var reminders []reminder
+	err := b.each("reminders", func(id string, data []byte) error {
+		var r reminder
+		if err := json.Unmarshal(data, &r); err != nil {
+			return err
+		}
+		if r.GuildID == guildID && r.UserID == userID {
+			r.ID = id
+			reminders = append(reminders, r)
+		}
+		return nil
+	})
+	sort.Slice(reminders, func(a, b int) bool { return reminders[a].Date.Before(reminders[b].Date) })
+	return reminders, err
+}
+
+func (b *boltStore) UpdateReminder(ctx context.Context, r reminder) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("reminders")).Put([]byte(r.ID), data)
+	})
+}
+
+func (b *boltStore) ClaimReminder(ctx context.Context, id, owner string, now, leaseUntil time.Time) (*reminder, error) {
+	var before reminder
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("reminders"))
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return errNotClaimed
+		}
+		if err := json.Unmarshal(data, &before); err != nil {
+			return err
+		}
+		if !before.claimable(owner, now) {
+			return errNotClaimed
+		}
+		claimed := before
+		claimed.ClaimedBy, claimed.LeaseUntil = owner, leaseUntil
+		data, err := json.Marshal(claimed)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	before.ID = id
+	return &before, nil
+}
+
+func (b *boltStore) CompleteReminder(ctx context.Context, r reminder, owner string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("reminders"))
+		var current reminder
+		data := bucket.Get([]byte(r.ID))
+		if data == nil {
+			return errLeaseLost
+		}
+		if err := json.Unmarshal(data, &current); err != nil {
+			return err
+		}
+		if current.ClaimedBy != owner {
+			return errLeaseLost
+		}
+		r.ClaimedBy, r.LeaseUntil = "", time.Time{}
+		r.Version = current.Version + 1
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(r.ID), data)
+	})
+}
+
+func (b *boltStore) DeleteReminder(ctx context.Context, id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("reminders")).Delete([]byte(id))
+	})
+}
+
+func (b *boltStore) ChangeReminder(ctx context.Context, r reminder, now time.Time) error {
+	return b.changeUnclaimedReminder(r.ID, now, func(bucket *bolt.Bucket, current reminder) error {
+		if current.Version != r.Version {
+			return errChanged
+		}
+		r.Version++
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(r.ID), data)
+	})
+}
+
+func (b *boltStore) CancelReminder(ctx context.Context, id string, now time.Time) error {
+	return b.changeUnclaimedReminder(id, now, func(bucket *bolt.Bucket, current reminder) error {
+		return bucket.Delete([]byte(id))
+	})
+}
+
+// changeUnclaimedReminder runs change in the same transaction as checking nobody's delivering the reminder
+func (b *boltStore) changeUnclaimedReminder(id string, now time.Time, change func(*bolt.Bucket, reminder) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("reminders"))
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return errNotFound
+		}
+		var current reminder
+		if err := json.Unmarshal(data, &current); err != nil {
+			return err
+		}
+		if current.claimed(now) {
+			return errClaimed
+		}
+		return change(bucket, current)
+	})
+}
+
+func (b *boltStore) AddMonth(ctx context.Context, m month) error {
+	_, err := b.add("musicmonth", m)
+	return err
+}
+
+// findMonth gets the month for which better returns true against every other candidate
+func (b *boltStore) findMonth(candidate func(m month) bool, better func(m, than month) bool) (*month, error) {
+	var found *month
+	err := b.each("musicmonth", func(id string, data []byte) error {
+		var m month
+		if err := json.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		if candidate(m) && (found == nil || better(m, *found)) {
+			m.ID = id
+			found = &m
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, errNotFound
+	}
+	return found, nil
+}
+
+func (b *boltStore) NextMonth(ctx context.Context, guildID string, after time.Time) (*month, error) {
+	return b.findMonth(
+		func(m month) bool { return m.GuildID == guildID && m.StartTime.After(after) },
+		func(m, than month) bool { return m.StartTime.Before(than.StartTime) },
+	)
+}
+
+func (b *boltStore) LatestMonth(ctx context.Context, guildID string, before time.Time) (*month, error) {
+	return b.findMonth(
+		func(m month) bool { return m.GuildID == guildID && m.StartTime.Before(before) },
+		func(m, than month) bool { return m.StartTime.After(than.StartTime) },
+	)
+}
+
+func (b *boltStore) UpdateMonth(ctx context.Context, m month) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("musicmonth")).Put([]byte(m.ID), data)
+	})
+}
+
+func (b *boltStore) DeleteMonth(ctx context.Context, id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("musicmonth")).Delete([]byte(id))
+	})
+}
+
+func (b *boltStore) AddMonthChange(ctx context.Context, c monthChange) error {
+	_, err := b.add("monthchanges", c)
+	return err
+}
+
+func (b *boltStore) MonthChanges(ctx context.Context, guildID string) ([]monthChange, error) {
+	var changes []monthChange
+	err := b.each("monthchanges", func(id string, data []byte) error {
+		var c monthChange
+		if err := json.Unmarshal(data, &c); err != nil {
+			return err
+		}
+		if c.GuildID == guildID {
+			c.ID = id
+			changes = append(changes, c)
+		}
+		return nil
+	})
+	sort.SliceStable(changes, func(a, b int) bool { return changes[a].Time.After(changes[b].Time) })
+	return changes, err
+}
+
+func songMatches(s song, guildID, monthName, userID string, day int) bool {
+	return s.GuildID == guildID && s.Month == monthName && (userID == "" || s.UserID == userID) && (day == 0 || s.Day == day)
+}
+
+func (b *boltStore) Songs(ctx context.Context, guildID, monthName, userID string, day int) ([]song, error) {
+	var songs []song
+	err := b.each("music", func(id string, data []byte) error {
+		var s song
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		if songMatches(s, guildID, monthName, userID, day) {
+			s.ID = id
+			songs = append(songs, s)
+		}
+		return nil
+	})
+	return songs, err
+}
+
+func (b *boltStore) SaveSong(ctx context.Context, s song) (*song, error) {
+	var replaced *song
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("music"))
+		var stale [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			var old song
+			if err := json.Unmarshal(v, &old); err != nil {
+				return err
+			}
+			if songMatches(old, s.GuildID, s.Month, s.UserID, s.Day) {
+				old.ID = string(k)
+				replaced = &old
+				stale = append(stale, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// Bolt doesn't like the bucket changing under ForEach, so delete afterwards
+		for _, k := range stale {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		_, err = boltInsert(bucket, s)
+		return err
+	})
+	return replaced, err
+}
+
+func (b *boltStore) Playlist(ctx context.Context, guildID, monthName, userID string, day int) (*playlist, error) {
+	var found *playlist
+	err := b.each("musicplaylists", func(id string, data []byte) error {
+		var p playlist
+		if err := json.Unmarshal(data, &p); err != nil {
+			return err
+		}
+		if p.GuildID == guildID && p.Month == monthName && p.UserID == userID && p.Day == day {
+			found = &p
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, errNotFound
+	}
+	return found, nil
+}
+
+func (b *boltStore) AddPlaylist(ctx context.Context, p playlist) error {
+	_, err := b.add("musicplaylists", p)
+	return err
+}
+
+// changePlaylistJob updates a job in one transaction. fn gets the job as stored, or nil if
+// there isn't one, and returns what to store instead or an error to leave it alone
+func (b *boltStore) changePlaylistJob(id string, fn func(j *playlistJob) (*playlistJob, error)) (*playlistJob, error) {
+	var changed *playlistJob
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("playlistjobs"))
+		var current *playlistJob
+		if data := bucket.Get([]byte(id)); data != nil {
+			current = &playlistJob{}
+			if err := json.Unmarshal(data, current); err != nil {
+				return err
+			}
+		}
+		var err error
+		changed, err = fn(current)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(changed)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	changed.ID = id
+	return changed, nil
+}
+
+func (b *boltStore) QueuePlaylistJob(ctx context.Context, j playlistJob) (*playlistJob, error) {
+	return b.changePlaylistJob(j.ID, func(current *playlistJob) (*playlistJob, error) {
+		if current != nil && current.pending() {
+			current.Waiters = append(current.Waiters, j.Waiters...)
+			return current, nil
+		}
+		j.Status = jobQueued
+		return &j, nil
+	})
+}
+
+func (b *boltStore) PendingPlaylistJobs(ctx context.Context) ([]playlistJob, error) {
+	var jobs []playlistJob
+	err := b.each("playlistjobs", func(id string, data []byte) error {
+		var j playlistJob
+		if err := json.Unmarshal(data, &j); err != nil {
+			return err
+		}
+		if j.pending() {
+			j.ID = id
+			jobs = append(jobs, j)
+		}
+		return nil
+	})
+	sort.SliceStable(jobs, func(a, b int) bool { return jobs[a].Queued.Before(jobs[b].Queued) })
+	return jobs, err
+}
+
+func (b *boltStore) ClaimPlaylistJob(ctx context.Context, id, owner string, now, leaseUntil time.Time) (*playlistJob, error) {
+	return b.changePlaylistJob(id, func(current *playlistJob) (*playlistJob, error) {
+		if current == nil || !current.claimable(owner, now) {
+			return nil, errNotClaimed
+		}
+		current.Status, current.ClaimedBy, current.LeaseUntil = jobRunning, owner, leaseUntil
+		return current, nil
+	})
+}
+
+func (b *boltStore) FinishPlaylistJob(ctx context.Context, id, owner, status, result string) (*playlistJob, error) {
+	return b.changePlaylistJob(id, func(current *playlistJob) (*playlistJob, error) {
+		if current == nil || current.Status != jobRunning || current.ClaimedBy != owner {
+			return nil, errLeaseLost
+		}
+		current.Status, current.Result = status, result
+		current.ClaimedBy, current.LeaseUntil = "", time.Time{}
+		return current, nil
+	})
+}
+
+func sameGrant(g, other grant) bool {
+	return g.GuildID == other.GuildID && g.Capability == other.Capability && g.RoleID == other.RoleID && g.UserID == other.UserID
+}
+
+// findGrants gets the keys of every grant in the bucket matching g
+func findGrants(bucket *bolt.Bucket, g grant) ([][]byte, error) {
+	var keys [][]byte
+	err := bucket.ForEach(func(k, v []byte) error {
+		var existing grant
+		if err := json.Unmarshal(v, &existing); err != nil {
+			return err
+		}
+		if sameGrant(g, existing) {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func (b *boltStore) AddGrant(ctx context.Context, g grant) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("permissions"))
+		keys, err := findGrants(bucket, g)
+		if err != nil || len(keys) > 0 {
+			return err
+		}
+		_, err = boltInsert(bucket, g)
+		return err
+	})
+}
+
+func (b *boltStore) RemoveGrant(ctx context.Context, g grant) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("permissions"))
+		keys, err := findGrants(bucket, g)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return errNotFound
+		}
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *boltStore) Grants(ctx context.Context, guildID string) ([]grant, error) {
+	var grants []grant
+	err := b.each("permissions", func(id string, data []byte) error {
+		var g grant
+		if err := json.Unmarshal(data, &g); err != nil {
+			return err
+		}
+		if g.GuildID == guildID {
+			g.ID = id
+			grants = append(grants, g)
+		}
+		return nil
+	})
+	return grants, err
+}
+
+func (b *boltStore) Setting(ctx context.Context, name string) ([]byte, error) {
+	var value []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		// Bolt's slices are only valid for the transaction
+		value = append([]byte(nil), tx.Bucket([]byte("settings")).Get([]byte(name))...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(value) == 0 {
+		return nil, errNotFound
+	}
+	return value, nil
+}
+
+func (b *boltStore) SaveSetting(ctx context.Context, name string, value []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("settings")).Put([]byte(name), value)
+	})
+}
+
+func (b *boltStore) SwapSetting(ctx context.Context, name string, old, value []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("settings"))
+		if !bytes.Equal(bucket.Get([]byte(name)), old) {
+			return errNotClaimed
+		}
+		return bucket.Put([]byte(name), value)
+	})
+}
+
+func (b *boltStore) UserTimezone(ctx context.Context, userID string) (string, error) {
+	var zone string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		zone = string(tx.Bucket([]byte("timezones")).Get([]byte(userID)))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if zone == "" {
+		return "", errNotFound
+	}
+	return zone, nil
+}
+
+func (b *boltStore) SaveUserTimezone(ctx context.Context, userID, zone string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("timezones")).Put([]byte(userID), []byte(zone))
+	})
+}
+
+func (b *boltStore) CalendarFeed(ctx context.Context, id string) (*calendarFeed, error) {
+	var feed *calendarFeed
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte("calendars")).Get([]byte(id))
+		if data == nil {
+			return errNotFound
+		}
+		feed = &calendarFeed{}
+		if err := json.Unmarshal(data, feed); err != nil {
+			return err
+		}
+		feed.ID = id
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+func (b *boltStore) SaveCalendarFeed(ctx context.Context, f calendarFeed) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("calendars"))
+		var old [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			var existing calendarFeed
+			if err := json.Unmarshal(v, &existing); err != nil {
+				return err
+			}
+			if existing.GuildID == f.GuildID && existing.UserID == f.UserID {
+				old = append(old, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// Bolt doesn't allow deleting while iterating
+		for _, k := range old {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return bucket.Put([]byte(f.ID), data)
+	})
+}
+
+func (b *boltStore) TagGuild(ctx context.Context, guildID string) (int, error) {
+	tagged := 0
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range boltBuckets {
+			bucket := tx.Bucket([]byte(name))
+			updates := map[string][]byte{}
+			err := bucket.ForEach(func(k, v []byte) error {
+				// Go through a map so every record type can be handled the same way
+				var record map[string]interface{}
+				if err := json.Unmarshal(v, &record); err != nil {
+					return err
+				}
+				if existing, ok := record["guildID"].(string); ok && existing != "" {
+					return nil
+				}
+				record["guildID"] = guildID
+				data, err := json.Marshal(record)
+				if err != nil {
+					return err
+				}
+				updates[string(k)] = data
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for k, data := range updates {
+				if err := bucket.Put([]byte(k), data); err != nil {
+					return err
+				}
+			}
+			tagged += len(updates)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return tagged, nil
+}
+
+func (b *boltStore) Close() error {
+	return b.db.Close()
+}
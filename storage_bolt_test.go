@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBoltStoreKeepsGuildsApart(t *testing.T) {
+	ctx := context.Background()
+	b, err := newBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	for _, guildID := range []string{"1", "2"} {
+		if err := b.AddMonth(ctx, month{GuildID: guildID, StartTime: start}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.SaveSong(ctx, song{GuildID: guildID, UserID: "100", Month: "Oct 2026", Day: 1, Song: "song for " + guildID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	songs, err := b.Songs(ctx, "1", "Oct 2026", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(songs) != 1 || songs[0].Song != "song for 1" {
+		t.Errorf("Guild 1 got songs %+v", songs)
+	}
+	if _, err := b.NextMonth(ctx, "3", start.AddDate(0, 0, -1)); err != errNotFound {
+		t.Errorf("Guild 3 found a month that isn't theirs: %v", err)
+	}
+}
+
+func TestBoltStoreTagGuild(t *testing.T) {
+	ctx := context.Background()
+	b, err := newBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	// Records from before guilds existed, plus one that already has a guild
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	b.AddMonth(ctx, month{StartTime: start, Days: []day{{Day: 1, Prompt: "birds"}}})
+	b.SaveSong(ctx, song{UserID: "100", Month: "Oct 2026", Day: 1, Song: "old"})
+	b.AddReminder(ctx, reminder{UserID: "100", Reminder: "old", Date: start})
+	b.AddPlaylist(ctx, playlist{Month: "Oct 2026", PlaylistID: "PL1"})
+	b.SaveSong(ctx, song{GuildID: "2", UserID: "100", Month: "Oct 2026", Day: 1, Song: "new"})
+
+	tagged, err := b.TagGuild(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tagged != 4 {
+		t.Errorf("Tagged %d records, want 4", tagged)
+	}
+
+	m, err := b.NextMonth(ctx, "1", start.AddDate(0, 0, -1))
+	if err != nil {
+		t.Fatalf("Tagged month not found: %v", err)
+	}
+	if len(m.Days) != 1 || m.Days[0].Prompt != "birds" {
+		t.Errorf("Month was mangled by tagging: %+v", m)
+	}
+	if songs, _ := b.Songs(ctx, "1", "Oct 2026", "100", 1); len(songs) != 1 || songs[0].Song != "old" {
+		t.Errorf("Guild 1 got songs %+v", songs)
+	}
+	if songs, _ := b.Songs(ctx, "2", "Oct 2026", "100", 1); len(songs) != 1 || songs[0].Song != "new" {
+		t.Errorf("Guild 2's song was re-tagged: %+v", songs)
+	}
+	if p, err := b.Playlist(ctx, "1", "Oct 2026", "", 0); err != nil || p.PlaylistID != "PL1" {
+		t.Errorf("Tagged playlist not found: %+v %v", p, err)
+	}
+	if tagged, _ := b.TagGuild(ctx, "1"); tagged != 0 {
+		t.Errorf("Tagging twice changed %d records", tagged)
+	}
+}
+
+func TestBoltStoreClaimReminder(t *testing.T) {
+	ctx := context.Background()
+	b, err := newBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	id, err := b.AddReminder(ctx, reminder{UserID: "100", Reminder: "feed the bird", Date: now.Add(-time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	later, _ := b.AddReminder(ctx, reminder{UserID: "100", Reminder: "later", Date: now.Add(time.Hour)})
+	if _, err := b.ClaimReminder(ctx, later, "a", now, now.Add(time.Minute)); err != errNotClaimed {
+		t.Errorf("Claimed a reminder that isn't due: %v", err)
+	}
+
+	before, err := b.ClaimReminder(ctx, id, "a", now, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.ID != id || before.ClaimedBy != "" {
+		t.Errorf("Claim returned %+v, want the unclaimed reminder", before)
+	}
+	if _, err := b.ClaimReminder(ctx, id, "b", now, now.Add(time.Minute)); err != errNotClaimed {
+		t.Errorf("Claimed a reminder someone else holds: %v", err)
+	}
+
+	// a's lease runs out, so b takes over and a can't finish
+	before, err = b.ClaimReminder(ctx, id, "b", now.Add(2*time.Minute), now.Add(3*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.ClaimedBy != "a" {
+		t.Errorf("Took over %+v, want a's claim shown", before)
+	}
+	before.Delivered = true
+	if err := b.CompleteReminder(ctx, *before, "a"); err != errLeaseLost {
+		t.Errorf("Completed a reminder after losing the claim: %v", err)
+	}
+	if err := b.CompleteReminder(ctx, *before, "b"); err != nil {
+		t.Fatal(err)
+	}
+	r, _ := b.Reminder(ctx, id)
+	if !r.Delivered || r.ClaimedBy != "" || !r.LeaseUntil.IsZero() {
+		t.Errorf("Completed reminder is %+v", r)
+	}
+	if _, err := b.ClaimReminder(ctx, id, "b", now.Add(3*time.Minute), now.Add(4*time.Minute)); err != errNotClaimed {
+		t.Errorf("Claimed a delivered reminder: %v", err)
+	}
+}
+
+func TestBoltStorePlaylistJobs(t *testing.T) {
+	ctx := context.Background()
+	b, err := newBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	id := playlistJobID("1", "Oct 2026", "", 0)
+	job := playlistJob{ID: id, GuildID: "1", Month: "Oct 2026", Queued: now, Waiters: []playlistWaiter{{Token: "first"}}}
+	if _, err := b.QueuePlaylistJob(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	other := playlistJob{ID: playlistJobID("1", "Oct 2026", "100", 0), GuildID: "1", Month: "Oct 2026", UserID: "100", Queued: now.Add(-time.Minute)}
+	b.QueuePlaylistJob(ctx, other)
+
+	// Asking for the same playlist again joins the job already queued
+	job.Waiters = []playlistWaiter{{Token: "second"}}
+	queued, err := b.QueuePlaylistJob(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued.Status != jobQueued || len(queued.Waiters) != 2 {
+		t.Errorf("Queued %+v, want one job with both waiters", queued)
+	}
+	pending, err := b.PendingPlaylistJobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].ID != other.ID || pending[1].ID != id {
+		t.Errorf("Got pending jobs %+v, want both oldest first", pending)
+	}
+
+	claimed, err := b.ClaimPlaylistJob(ctx, id, "a", now, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed.Status != jobRunning || claimed.ClaimedBy != "a" {
+		t.Errorf("Claimed %+v, want it running for a", claimed)
+	}
+	if _, err := b.ClaimPlaylistJob(ctx, id, "b", now, now.Add(time.Minute)); err != errNotClaimed {
+		t.Errorf("Claimed a job someone else is running: %v", err)
+	}
+
+	// Someone joins while it's running, then a's lease runs out and b takes over
+	job.Waiters = []playlistWaiter{{Token: "third"}}
+	b.QueuePlaylistJob(ctx, job)
+	if _, err := b.ClaimPlaylistJob(ctx, id, "b", now.Add(2*time.Minute), now.Add(3*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.FinishPlaylistJob(ctx, id, "a", jobDone, "late"); err != errLeaseLost {
+		t.Errorf("Finished a job after losing the claim: %v", err)
+	}
+	finished, err := b.FinishPlaylistJob(ctx, id, "b", jobDone, "Playlist for Oct 2026")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if finished.Status != jobDone || finished.ClaimedBy != "" || len(finished.Waiters) != 3 {
+		t.Errorf("Finished %+v, want it done with all three waiters", finished)
+	}
+
+	// Once it's finished, asking again starts afresh
+	job.Waiters = []playlistWaiter{{Token: "fourth"}}
+	queued, _ = b.QueuePlaylistJob(ctx, job)
+	if queued.Status != jobQueued || len(queued.Waiters) != 1 || queued.Result != "" {
+		t.Errorf("Queued %+v after the last finished, want a new job", queued)
+	}
+}
+
+func TestBoltStoreSwapSetting(t *testing.T) {
+	ctx := context.Background()
+	b, err := newBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if err := b.SwapSetting(ctx, "day", nil, []byte("mon")); err != nil {
+		t.Fatal(err)
+	}
+	// A second instance that read the setting before the swap doesn't get it too
+	if err := b.SwapSetting(ctx, "day", nil, []byte("mon")); err != errNotClaimed {
+		t.Errorf("Swapped a setting that had changed: %v", err)
+	}
+	if err := b.SwapSetting(ctx, "day", []byte("mon"), []byte("tue")); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := b.Setting(ctx, "day"); string(value) != "tue" {
+		t.Errorf("Setting is %q, want tue", value)
+	}
+}
+
+func TestBoltStoreChangeAfterDelivery(t *testing.T) {
+	ctx := context.Background()
+	b, err := newBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	id, _ := b.AddReminder(ctx, reminder{UserID: "100", Reminder: "feed the bird", Date: now.Add(-time.Minute)})
+	found, err := b.Reminder(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// It's delivered between someone finding it and saving their change
+	claimed, err := b.ClaimReminder(ctx, id, "a", now, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed.Delivered = true
+	if err := b.CompleteReminder(ctx, *claimed, "a"); err != nil {
+		t.Fatal(err)
+	}
+	found.Reminder = "feed the cat"
+	if err := b.ChangeReminder(ctx, *found, now); err != errChanged {
+		t.Errorf("Changed a reminder delivered since it was read: %v", err)
+	}
+	if r, _ := b.Reminder(ctx, id); !r.Delivered || r.Reminder != "feed the bird" {
+		t.Errorf("Reminder is now %+v, want it left delivered", r)
+	}
+
+	// Reading it again gets a change through, once
+	fresh, _ := b.Reminder(ctx, id)
+	fresh.Reminder = "feed the cat"
+	if err := b.ChangeReminder(ctx, *fresh, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ChangeReminder(ctx, *fresh, now); err != errChanged {
+		t.Errorf("Saved the same stale change twice: %v", err)
+	}
+}
@@ -0,0 +1,606 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	firebase "firebase.google.com/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type firestoreStore struct {
+	client *firestore.Client
+}
+
+func newFirestoreStore(ctx context.Context, project string) (*firestoreStore, error) {
+	app, err := firebase.NewApp(ctx, &firebase.Config{ProjectID: project})
+	if err != nil {
+		return nil, err
+	}
+	client, err := app.Firestore(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &firestoreStore{client: client}, nil
+}
+
+func (f *firestoreStore) AddReminder(ctx context.Context, r reminder) (string, error) {
+	ref, _, err := f.client.Collection("reminders").Add(ctx, r)
+	if err != nil {
+		return "", err
+	}
+	return ref.ID, nil
+}
+
+func (f *firestoreStore) DueReminders(ctx context.Context, before time.Time) ([]reminder, error) {
+	docs, err := f.client.Collection("reminders").Where("date", "<", before).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	reminders := make([]reminder, 0, len(docs))
+	for _, doc := range docs {
+		var r reminder
+		if err := doc.DataTo(&r); err != nil {
+			return nil, err
+		}
+		// Filtering here saves a composite index on date and dead
+		if r.Dead {
+			continue
+		}
+		r.ID = doc.Ref.ID
+		reminders = append(reminders, r)
+	}
+	return reminders, nil
+}
+
+func (f *firestoreStore) UndeliveredReminders(ctx context.Context, guildID string) ([]reminder, error) {
+	docs, err := f.client.Collection("reminders").Where("guildID", "==", guildID).Where("dead", "==", true).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	reminders := make([]reminder, 0, len(docs))
+	for _, doc := range docs {
+		var r reminder
+		if err := doc.DataTo(&r); err != nil {
+			return nil, err
+		}
+		r.ID = doc.Ref.ID
+		reminders = append(reminders, r)
+	}
+	sort.Slice(reminders, func(a, b int) bool { return reminders[a].Date.Before(reminders[b].Date) })
+	return reminders, nil
+}
+
+func (f *firestoreStore) Reminder(ctx context.Context, id string) (*reminder, error) {
+	doc, err := f.client.Collection("reminders").Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var r reminder
+	if err := doc.DataTo(&r); err != nil {
+		return nil, err
+	}
+	r.ID = doc.Ref.ID
+	return &r, nil
+}
+
+// UserReminders sorts here rather than in the query, which would need another composite index
+func (f *firestoreStore) UserReminders(ctx context.Context, guildID, userID string) ([]reminder, error) {
+	docs, err := f.client.Collection("reminders").Where("guildID", "==", guildID).Where("userID", "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	reminders := make([]reminder, 0, len(docs))
+	for _, doc := range docs {
+		var r reminder
+		if err := doc.DataTo(&r); err != nil {
+			return nil, err
+		}
+		r.ID = doc.Ref.ID
+		reminders = append(reminders, r)
+	}
+	sort.Slice(reminders, func(a, b int) bool { return reminders[a].Date.Before(reminders[b].Date) })
+	return reminders, nil
+}
+
+func (f *firestoreStore) UpdateReminder(ctx context.Context, r reminder) error {
+	_, err := f.client.Collection("reminders").Doc(r.ID).Set(ctx, r)
+	return err
+}
+
+func (f *firestoreStore) ClaimReminder(ctx context.Context, id, owner string, now, leaseUntil time.Time) (*reminder, error) {
+	ref := f.client.Collection("reminders").Doc(id)
+	var before reminder
+	err := f.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return errNotClaimed
+		}
+		if err != nil {
+			return err
+		}
+		before = reminder{}
+		if err := doc.DataTo(&before); err != nil {
+			return err
+		}
+		if !before.claimable(owner, now) {
+			return errNotClaimed
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "claimedBy", Value: owner},
+			{Path: "leaseUntil", Value: leaseUntil},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	before.ID = id
+	return &before, nil
+}
+
+func (f *firestoreStore) CompleteReminder(ctx context.Context, r reminder, owner string) error {
+	ref := f.client.Collection("reminders").Doc(r.ID)
+	return f.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return errLeaseLost
+		}
+		if err != nil {
+			return err
+		}
+		var current reminder
+		if err := doc.DataTo(&current); err != nil {
+			return err
+		}
+		if current.ClaimedBy != owner {
+			return errLeaseLost
+		}
+		r.ClaimedBy, r.LeaseUntil = "", time.Time{}
+		r.Version = current.Version + 1
+		return tx.Set(ref, r)
+	})
+}
+
+func (f *firestoreStore) DeleteReminder(ctx context.Context, id string) error {
+	_, err := f.client.Collection("reminders").Doc(id).Delete(ctx)
+	return err
+}
+
+func (f *firestoreStore) ChangeReminder(ctx context.Context, r reminder, now time.Time) error {
+	return f.changeUnclaimedReminder(ctx, r.ID, now, func(tx *firestore.Transaction, ref *firestore.DocumentRef, current reminder) error {
+		if current.Version != r.Version {
+			return errChanged
+		}
+		r.Version++
+		return tx.Set(ref, r)
+	})
+}
+
+func (f *firestoreStore) CancelReminder(ctx context.Context, id string, now time.Time) error {
+	return f.changeUnclaimedReminder(ctx, id, now, func(tx *firestore.Transaction, ref *firestore.DocumentRef, current reminder) error {
+		return tx.Delete(ref)
+	})
+}
+
+// changeUnclaimedReminder runs change in the same transaction as checking nobody's delivering the reminder
+func (f *firestoreStore) changeUnclaimedReminder(ctx context.Context, id string, now time.Time, change func(*firestore.Transaction, *firestore.DocumentRef, reminder) error) error {
+	ref := f.client.Collection("reminders").Doc(id)
+	return f.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return errNotFound
+		}
+		if err != nil {
+			return err
+		}
+		var current reminder
+		if err := doc.DataTo(&current); err != nil {
+			return err
+		}
+		if current.claimed(now) {
+			return errClaimed
+		}
+		return change(tx, ref, current)
+	})
+}
+
+func (f *firestoreStore) AddMonth(ctx context.Context, m month) error {
+	_, _, err := f.client.Collection("musicmonth").Add(ctx, m)
+	return err
+}
+
+// NextMonth and LatestMonth need a composite index on guildID and StartTime
+func (f *firestoreStore) NextMonth(ctx context.Context, guildID string, after time.Time) (*month, error) {
+	return f.firstMonth(ctx, f.client.Collection("musicmonth").Where("guildID", "==", guildID).Where("StartTime", ">", after).OrderBy("StartTime", firestore.Asc))
+}
+
+func (f *firestoreStore) LatestMonth(ctx context.Context, guildID string, before time.Time) (*month, error) {
+	return f.firstMonth(ctx, f.client.Collection("musicmonth").Where("guildID", "==", guildID).Where("StartTime", "<", before).OrderBy("StartTime", firestore.Desc))
+}
+
+func (f *firestoreStore) firstMonth(ctx context.Context, query firestore.Query) (*month, error) {
+	docs, err := query.Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, errNotFound
+	}
+	var m month
+	if err := docs[0].DataTo(&m); err != nil {
+		return nil, err
+	}
+	m.ID = docs[0].Ref.ID
+	return &m, nil
+}
+
+func (f *firestoreStore) UpdateMonth(ctx context.Context, m month) error {
+	_, err := f.client.Collection("musicmonth").Doc(m.ID).Set(ctx, m)
+	return err
+}
+
+func (f *firestoreStore) DeleteMonth(ctx context.Context, id string) error {
+	_, err := f.client.Collection("musicmonth").Doc(id).Delete(ctx)
+	return err
+}
+
+func (f *firestoreStore) AddMonthChange(ctx context.Context, c monthChange) error {
+	_, _, err := f.client.Collection("monthchanges").Add(ctx, c)
+	return err
+}
+
+func (f *firestoreStore) MonthChanges(ctx context.Context, guildID string) ([]monthChange, error) {
+	docs, err := f.client.Collection("monthchanges").Where("guildID", "==", guildID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]monthChange, 0, len(docs))
+	for _, doc := range docs {
+		var c monthChange
+		if err := doc.DataTo(&c); err != nil {
+			return nil, err
+		}
+		c.ID = doc.Ref.ID
+		changes = append(changes, c)
+	}
+	// Sorting here saves needing another composite index
+	sort.SliceStable(changes, func(a, b int) bool { return changes[a].Time.After(changes[b].Time) })
+	return changes, nil
+}
+
+func (f *firestoreStore) songQuery(guildID, monthName, userID string, day int) firestore.Query {
+	query := f.client.Collection("music").Where("guildID", "==", guildID).Where("month", "==", monthName)
+	if userID != "" {
+		query = query.Where("userID", "==", userID)
+	}
+	if day != 0 {
+		query = query.Where("day", "==", day)
+	}
+	return query
+}
+
+func (f *firestoreStore) Songs(ctx context.Context, guildID, monthName, userID string, day int) ([]song, error) {
+	docs, err := f.songQuery(guildID, monthName, userID, day).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	songs := make([]song, 0, len(docs))
+	for _, doc := range docs {
+		var s song
+		if err := doc.DataTo(&s); err != nil {
+			return nil, err
+		}
+		s.ID = doc.Ref.ID
+		songs = append(songs, s)
+	}
+	return songs, nil
+}
+
+func (f *firestoreStore) SaveSong(ctx context.Context, s song) (*song, error) {
+	var replaced *song
+	err := f.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		replaced = nil
+		docs, err := tx.Documents(f.songQuery(s.GuildID, s.Month, s.UserID, s.Day)).GetAll()
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			var old song
+			if err := doc.DataTo(&old); err != nil {
+				return err
+			}
+			old.ID = doc.Ref.ID
+			replaced = &old
+			if err := tx.Delete(doc.Ref); err != nil {
+				return err
+			}
+		}
+		return tx.Create(f.client.Collection("music").NewDoc(), s)
+	})
+	return replaced, err
+}
+
+func (f *firestoreStore) Playlist(ctx context.Context, guildID, monthName, userID string, day int) (*playlist, error) {
+	docs, err := f.client.Collection("musicplaylists").Where("guildID", "==", guildID).Where("userID", "==", userID).Where("month", "==", monthName).Where("day", "==", day).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, errNotFound
+	}
+	var p playlist
+	if err := docs[0].DataTo(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (f *firestoreStore) AddPlaylist(ctx context.Context, p playlist) error {
+	_, _, err := f.client.Collection("musicplaylists").Add(ctx, p)
+	return err
+}
+
+// changePlaylistJob updates a job in a transaction. fn gets the job as stored, or nil if
+// there isn't one, and returns what to store instead or an error to leave it alone
+func (f *firestoreStore) changePlaylistJob(ctx context.Context, id string, fn func(j *playlistJob) (*playlistJob, error)) (*playlistJob, error) {
+	ref := f.client.Collection("playlistjobs").Doc(id)
+	var changed *playlistJob
+	err := f.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var current *playlistJob
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			current = &playlistJob{}
+			if err := doc.DataTo(current); err != nil {
+				return err
+			}
+		}
+		changed, err = fn(current)
+		if err != nil {
+			return err
+		}
+		return tx.Set(ref, changed)
+	})
+	if err != nil {
+		return nil, err
+	}
+	changed.ID = id
+	return changed, nil
+}
+
+func (f *firestoreStore) QueuePlaylistJob(ctx context.Context, j playlistJob) (*playlistJob, error) {
+	return f.changePlaylistJob(ctx, j.ID, func(current *playlistJob) (*playlistJob, error) {
+		if current != nil && current.pending() {
+			current.Waiters = append(current.Waiters, j.Waiters...)
+			return current, nil
+		}
+		j.Status = jobQueued
+		return &j, nil
+	})
+}
+
+func (f *firestoreStore) PendingPlaylistJobs(ctx context.Context) ([]playlistJob, error) {
+	docs, err := f.client.Collection("playlistjobs").Where("status", "in", []string{jobQueued, jobRunning}).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]playlistJob, 0, len(docs))
+	for _, doc := range docs {
+		var j playlistJob
+		if err := doc.DataTo(&j); err != nil {
+			return nil, err
+		}
+		j.ID = doc.Ref.ID
+		jobs = append(jobs, j)
+	}
+	// Sorting here saves a composite index on status and queued
+	sort.SliceStable(jobs, func(a, b int) bool { return jobs[a].Queued.Before(jobs[b].Queued) })
+	return jobs, nil
+}
+
+func (f *firestoreStore) ClaimPlaylistJob(ctx context.Context, id, owner string, now, leaseUntil time.Time) (*playlistJob, error) {
+	return f.changePlaylistJob(ctx, id, func(current *playlistJob) (*playlistJob, error) {
+		if current == nil || !current.claimable(owner, now) {
+			return nil, errNotClaimed
+		}
+		current.Status, current.ClaimedBy, current.LeaseUntil = jobRunning, owner, leaseUntil
+		return current, nil
+	})
+}
+
+func (f *firestoreStore) FinishPlaylistJob(ctx context.Context, id, owner, jobStatus, result string) (*playlistJob, error) {
+	return f.changePlaylistJob(ctx, id, func(current *playlistJob) (*playlistJob, error) {
+		if current == nil || current.Status != jobRunning || current.ClaimedBy != owner {
+			return nil, errLeaseLost
+		}
+		current.Status, current.Result = jobStatus, result
+		current.ClaimedBy, current.LeaseUntil = "", time.Time{}
+		return current, nil
+	})
+}
+
+func (f *firestoreStore) grantQuery(g grant) firestore.Query {
+	return f.client.Collection("permissions").Where("guildID", "==", g.GuildID).Where("capability", "==", g.Capability).Where("roleID", "==", g.RoleID).Where("userID", "==", g.UserID)
+}
+
+func (f *firestoreStore) AddGrant(ctx context.Context, g grant) error {
+	return f.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docs, err := tx.Documents(f.grantQuery(g)).GetAll()
+		if err != nil || len(docs) > 0 {
+			return err
+		}
+		return tx.Create(f.client.Collection("permissions").NewDoc(), g)
+	})
+}
+
+func (f *firestoreStore) RemoveGrant(ctx context.Context, g grant) error {
+	return f.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docs, err := tx.Documents(f.grantQuery(g)).GetAll()
+		if err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			return errNotFound
+		}
+		for _, doc := range docs {
+			if err := tx.Delete(doc.Ref); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (f *firestoreStore) Grants(ctx context.Context, guildID string) ([]grant, error) {
+	docs, err := f.client.Collection("permissions").Where("guildID", "==", guildID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	grants := make([]grant, 0, len(docs))
+	for _, doc := range docs {
+		var g grant
+		if err := doc.DataTo(&g); err != nil {
+			return nil, err
+		}
+		g.ID = doc.Ref.ID
+		grants = append(grants, g)
+	}
+	return grants, nil
+}
+
+// setting is a document in the settings collection, named after the setting
+type setting struct {
+	Value []byte `firestore:"value"`
+}
+
+func (f *firestoreStore) Setting(ctx context.Context, name string) ([]byte, error) {
+	doc, err := f.client.Collection("settings").Doc(name).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s setting
+	if err := doc.DataTo(&s); err != nil {
+		return nil, err
+	}
+	return s.Value, nil
+}
+
+func (f *firestoreStore) SaveSetting(ctx context.Context, name string, value []byte) error {
+	_, err := f.client.Collection("settings").Doc(name).Set(ctx, setting{Value: value})
+	return err
+}
+
+func (f *firestoreStore) SwapSetting(ctx context.Context, name string, old, value []byte) error {
+	ref := f.client.Collection("settings").Doc(name)
+	return f.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var current setting
+		doc, err := tx.Get(ref)
+		if err == nil {
+			err = doc.DataTo(&current)
+		} else if status.Code(err) == codes.NotFound {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(current.Value, old) {
+			return errNotClaimed
+		}
+		return tx.Set(ref, setting{Value: value})
+	})
+}
+
+// userTimezone is a document in the timezones collection, named after the user
+type userTimezone struct {
+	Zone string `firestore:"zone"`
+}
+
+func (f *firestoreStore) UserTimezone(ctx context.Context, userID string) (string, error) {
+	doc, err := f.client.Collection("timezones").Doc(userID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return "", errNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	var z userTimezone
+	if err := doc.DataTo(&z); err != nil {
+		return "", err
+	}
+	return z.Zone, nil
+}
+
+func (f *firestoreStore) SaveUserTimezone(ctx context.Context, userID, zone string) error {
+	_, err := f.client.Collection("timezones").Doc(userID).Set(ctx, userTimezone{Zone: zone})
+	return err
+}
+
+func (f *firestoreStore) CalendarFeed(ctx context.Context, id string) (*calendarFeed, error) {
+	doc, err := f.client.Collection("calendars").Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var feed calendarFeed
+	if err := doc.DataTo(&feed); err != nil {
+		return nil, err
+	}
+	feed.ID = id
+	return &feed, nil
+}
+
+func (f *firestoreStore) SaveCalendarFeed(ctx context.Context, feed calendarFeed) error {
+	calendars := f.client.Collection("calendars")
+	return f.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		old, err := tx.Documents(calendars.Where("guildID", "==", feed.GuildID).Where("userID", "==", feed.UserID)).GetAll()
+		if err != nil {
+			return err
+		}
+		for _, doc := range old {
+			if err := tx.Delete(doc.Ref); err != nil {
+				return err
+			}
+		}
+		return tx.Set(calendars.Doc(feed.ID), feed)
+	})
+}
+
+func (f *firestoreStore) TagGuild(ctx context.Context, guildID string) (int, error) {
+	tagged := 0
+	for _, collection := range []string{"reminders", "musicmonth", "music", "musicplaylists"} {
+		// Firestore can't query for a missing field, so check every document
+		docs, err := f.client.Collection(collection).Documents(ctx).GetAll()
+		if err != nil {
+			return tagged, err
+		}
+		for _, doc := range docs {
+			if existing, ok := doc.Data()["guildID"].(string); ok && existing != "" {
+				continue
+			}
+			_, err := doc.Ref.Update(ctx, []firestore.Update{{Path: "guildID", Value: guildID}})
+			if err != nil {
+				return tagged, err
+			}
+			tagged++
+		}
+	}
+	return tagged, nil
+}
+
+func (f *firestoreStore) Close() error {
+	return f.client.Close()
+}
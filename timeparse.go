@@ -0,0 +1,318 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// whenExamples is shown to anyone whose time couldn't be understood
+const whenExamples = `Try something like "in 2 hours", "tomorrow 9am", "next friday at 18:00" or "2026-12-25 10:00"`
+
+// defaultHour is when something on a given day happens if no time is given
+const defaultHour = 9
+
+// parseWhen works out the time someone means, relative to now and in now's location. It understands
+//   - durations like "5d3h30m", "in 2 weeks", "in an hour" or "3 days and 4 hours"
+//   - days like "today", "tomorrow", "friday", "next friday", "2026-12-25", "25 december" or "dec 25th 2027"
+//   - times like "9am", "9:30 pm", "18:00", "noon" or "midnight"
+//
+// A day and a time can come in either order. A day on its own means 9am, and a time on its own
+// means the next time it comes round. "friday" is today if it's Friday and the time's still to
+// come, where "next friday" is always a later day. The result is always in the future
+func parseWhen(input string, now time.Time) (time.Time, error) {
+	text := strings.ToLower(strings.TrimSpace(strings.Replace(input, ",", " ", -1)))
+	if text == "" {
+		return time.Time{}, errors.New("you didn't say when")
+	}
+
+	when, ok, err := parseDuration(text, now)
+	if !ok {
+		when, err = parseDate(text, now)
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !when.After(now) {
+		return time.Time{}, errors.New("that's not in the future")
+	}
+	return when, nil
+}
+
+var durationPart = regexp.MustCompile(`^(\d+|an?\b)\s*([a-z]+)\s*`)
+
+// durationUnits maps everything a unit might be written as to what it's short for
+var durationUnits = map[string]string{
+	"s": "s", "sec": "s", "secs": "s", "second": "s", "seconds": "s",
+	"m": "m", "min": "m", "mins": "m", "minute": "m", "minutes": "m",
+	"h": "h", "hr": "h", "hrs": "h", "hour": "h", "hours": "h",
+	"d": "d", "day": "d", "days": "d",
+	"w": "w", "wk": "w", "wks": "w", "week": "w", "weeks": "w",
+	"mo": "mo", "mos": "mo", "month": "mo", "months": "mo",
+	"y": "y", "yr": "y", "yrs": "y", "year": "y", "years": "y",
+}
+
+// parseDuration handles times given as an amount of time from now. If the text doesn't look
+// like a duration, ok is false so it can be tried as a date instead
+func parseDuration(text string, now time.Time) (when time.Time, ok bool, err error) {
+	rest := text
+	explicit := strings.HasPrefix(rest, "in ")
+	rest = strings.TrimPrefix(rest, "in ")
+	rest = strings.Join(strings.Fields(strings.Replace(" "+rest+" ", " and ", " ", -1)), " ")
+
+	var years, months, days int
+	var clock time.Duration
+	for rest != "" {
+		m := durationPart.FindStringSubmatch(rest)
+		if m == nil || durationUnits[m[2]] == "" {
+			if explicit {
+				return time.Time{}, true, fmt.Errorf("I don't know how long %q is", text)
+			}
+			return time.Time{}, false, nil
+		}
+		n := 1
+		if m[1] != "a" && m[1] != "an" {
+			n, err = strconv.Atoi(m[1])
+			if err != nil {
+				return time.Time{}, true, fmt.Errorf("%v is too big a number", m[1])
+			}
+		}
+		switch durationUnits[m[2]] {
+		case "s":
+			clock += time.Duration(n) * time.Second
+		case "m":
+			clock += time.Duration(n) * time.Minute
+		case "h":
+			clock += time.Duration(n) * time.Hour
+		case "d":
+			days += n
+		case "w":
+			days += 7 * n
+		case "mo":
+			months += n
+		case "y":
+			years += n
+		}
+		rest = rest[len(m[0]):]
+	}
+	// Whole days go by the calendar, so "1d" is the same time tomorrow even over a clock change
+	return now.AddDate(years, months, days).Add(clock), true, nil
+}
+
+var (
+	isoDate   = regexp.MustCompile(`^(\d{4})-(\d{2})-(\d{2})$`)
+	clockTime = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)?$`)
+	dayNumber = regexp.MustCompile(`^(\d{1,2})(st|nd|rd|th)?$`)
+	year      = regexp.MustCompile(`^\d{4}$`)
+	// spacedMeridiem joins "9 am" into "9am" so it's one word
+	spacedMeridiem = regexp.MustCompile(`(\d)\s+(am|pm)\b`)
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday, "tues": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+func monthNamed(word string) (time.Month, bool) {
+	if len(word) < 3 {
+		return 0, false
+	}
+	for m := time.January; m <= time.December; m++ {
+		name := strings.ToLower(m.String())
+		if word == name || word == name[:3] || (word == "sept" && m == time.September) {
+			return m, true
+		}
+	}
+	return 0, false
+}
+
+// dateSpec is what's been said about a date so far
+type dateSpec struct {
+	set      bool
+	relative int // days from today, for today and tomorrow
+	weekday  *time.Weekday
+	next     bool
+	year     int // 0 if not given
+	month    time.Month
+	day      int
+}
+
+// parseDate handles times given as a day, a time of day, or both
+func parseDate(text string, now time.Time) (time.Time, error) {
+	words := strings.Fields(spacedMeridiem.ReplaceAllString(text, "$1$2"))
+	var date dateSpec
+	hour, minute := -1, 0
+
+	setDate := func(d dateSpec) error {
+		if date.set {
+			return errors.New("that's more than one day")
+		}
+		d.set = true
+		date = d
+		return nil
+	}
+
+	for n := 0; n < len(words); n++ {
+		word := words[n]
+		var err error
+		switch {
+		case word == "at" || word == "on" || word == "the":
+			continue
+		case word == "today":
+			err = setDate(dateSpec{relative: 0})
+		case word == "tomorrow":
+			err = setDate(dateSpec{relative: 1})
+		case word == "next" || word == "this":
+			if n+1 >= len(words) {
+				return time.Time{}, fmt.Errorf("%v what?", word)
+			}
+			wd, ok := weekdays[words[n+1]]
+			if !ok {
+				return time.Time{}, fmt.Errorf("I only understand %q before a day of the week", word)
+			}
+			n++
+			err = setDate(dateSpec{weekday: &wd, next: word == "next"})
+		case weekdays[word] != 0 || word == "sunday" || word == "sun":
+			wd := weekdays[word]
+			err = setDate(dateSpec{weekday: &wd})
+		case isoDate.MatchString(word):
+			m := isoDate.FindStringSubmatch(word)
+			y, _ := strconv.Atoi(m[1])
+			mo, _ := strconv.Atoi(m[2])
+			d, _ := strconv.Atoi(m[3])
+			err = setDate(dateSpec{year: y, month: time.Month(mo), day: d})
+		case word == "noon" || word == "midday" || word == "midnight" || (clockTime.MatchString(word) && !dayNumber.MatchString(word)):
+			if hour >= 0 {
+				return time.Time{}, errors.New("that's more than one time")
+			}
+			hour, minute, err = parseClock(word)
+		default:
+			// Only dates with month names are left: "25 december", "dec 25th" and so on
+			var d dateSpec
+			d, n, err = parseDayMonth(words, n)
+			if err == nil {
+				err = setDate(d)
+			}
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	if !date.set && hour < 0 {
+		return time.Time{}, errors.New("I couldn't find a day or a time in that")
+	}
+
+	if hour < 0 {
+		hour = defaultHour
+	}
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, hour, minute, 0, 0, now.Location())
+	}
+	today := at(now.Year(), now.Month(), now.Day())
+
+	switch {
+	case !date.set:
+		// Just a time, so the next time it comes round
+		if !today.After(now) {
+			return today.AddDate(0, 0, 1), nil
+		}
+		return today, nil
+	case date.weekday != nil:
+		ahead := (int(*date.weekday) - int(now.Weekday()) + 7) % 7
+		if ahead == 0 && (date.next || !today.After(now)) {
+			ahead = 7
+		}
+		return today.AddDate(0, 0, ahead), nil
+	case date.month == 0:
+		return today.AddDate(0, 0, date.relative), nil
+	}
+
+	y := date.year
+	if y == 0 {
+		y = now.Year()
+	}
+	when := at(y, date.month, date.day)
+	if when.Month() != date.month || when.Day() != date.day {
+		return time.Time{}, fmt.Errorf("%v doesn't have a day %d", date.month, date.day)
+	}
+	if date.year == 0 && !when.After(now) {
+		// It's been this year already, so they must mean next year's
+		when = at(y+1, date.month, date.day)
+	}
+	return when, nil
+}
+
+// parseClock turns "9am", "9:30pm", "18:00", "noon" and so on into an hour and minute
+func parseClock(word string) (int, int, error) {
+	switch word {
+	case "noon", "midday":
+		return 12, 0, nil
+	case "midnight":
+		return 0, 0, nil
+	}
+	m := clockTime.FindStringSubmatch(word)
+	hour, _ := strconv.Atoi(m[1])
+	minute := 0
+	if m[2] != "" {
+		minute, _ = strconv.Atoi(m[2])
+	}
+	if m[3] != "" {
+		if hour < 1 || hour > 12 {
+			return 0, 0, fmt.Errorf("%v isn't a time", word)
+		}
+		hour %= 12
+		if m[3] == "pm" {
+			hour += 12
+		}
+	}
+	if hour > 23 || minute > 59 {
+		return 0, 0, fmt.Errorf("%v isn't a time", word)
+	}
+	return hour, minute, nil
+}
+
+// parseDayMonth reads a date written with a month name starting at words[n], returning the
+// index of the last word it used
+func parseDayMonth(words []string, n int) (dateSpec, int, error) {
+	var d dateSpec
+	if month, ok := monthNamed(words[n]); ok {
+		// "december 25th"
+		if n+1 >= len(words) || !dayNumber.MatchString(words[n+1]) {
+			return d, n, fmt.Errorf("which day of %v?", month)
+		}
+		d.month = month
+		d.day, _ = strconv.Atoi(dayNumber.FindStringSubmatch(words[n+1])[1])
+		n++
+	} else if dayNumber.MatchString(words[n]) {
+		// "25th december"
+		month, ok := time.Month(0), false
+		if n+1 < len(words) {
+			month, ok = monthNamed(words[n+1])
+		}
+		if !ok {
+			// A bare number could be a day or a time, so ask rather than guess
+			return d, n, fmt.Errorf("is %v a time? Say %vam, %vpm or %v:00", words[n], words[n], words[n], words[n])
+		}
+		d.month = month
+		d.day, _ = strconv.Atoi(dayNumber.FindStringSubmatch(words[n])[1])
+		n++
+	} else {
+		return d, n, fmt.Errorf("I don't know what %q means", words[n])
+	}
+	if n+1 < len(words) && year.MatchString(words[n+1]) {
+		d.year, _ = strconv.Atoi(words[n+1])
+		n++
+	}
+	return d, n, nil
+}
+
+// reminderTimeFormat is how a resolved time is echoed back, so there's no doubt what was understood
+const reminderTimeFormat = "Monday 2 January 2006 at 15:04 MST"
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWhen(t *testing.T) {
+	// A Friday afternoon
+	now := time.Date(2026, 10, 16, 14, 30, 0, 0, time.UTC)
+	at := func(y int, m time.Month, d, h, min int) time.Time {
+		return time.Date(y, m, d, h, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		input string
+		want  time.Time
+	}{
+		// Durations
+		{"5d3h30m", at(2026, 10, 21, 18, 0)},
+		{"1d2h30m", at(2026, 10, 17, 17, 0)},
+		{"30m", at(2026, 10, 16, 15, 0)},
+		{"90s", at(2026, 10, 16, 14, 31).Add(30 * time.Second)},
+		{"in 2 weeks", at(2026, 10, 30, 14, 30)},
+		{"in an hour", at(2026, 10, 16, 15, 30)},
+		{"in a week", at(2026, 10, 23, 14, 30)},
+		{"in 3 months", at(2027, 1, 16, 14, 30)},
+		{"in 1 year", at(2027, 10, 16, 14, 30)},
+		{"2 hours and 15 minutes", at(2026, 10, 16, 16, 45)},
+		{"1 week, 2 days", at(2026, 10, 25, 14, 30)},
+		{"2w", at(2026, 10, 30, 14, 30)},
+		{"In 10 Mins", at(2026, 10, 16, 14, 40)},
+
+		// Times on their own
+		{"9 pm", at(2026, 10, 16, 21, 0)},
+		{"9am", at(2026, 10, 17, 9, 0)},
+		{"at noon", at(2026, 10, 17, 12, 0)},
+		{"midnight", at(2026, 10, 17, 0, 0)},
+		{"18:00", at(2026, 10, 16, 18, 0)},
+		{"14:30", at(2026, 10, 17, 14, 30)},
+		{"12am", at(2026, 10, 17, 0, 0)},
+		{"12:15pm", at(2026, 10, 17, 12, 15)},
+
+		// Days, with and without times
+		{"tomorrow", at(2026, 10, 17, 9, 0)},
+		{"tomorrow 9am", at(2026, 10, 17, 9, 0)},
+		{"9:30pm tomorrow", at(2026, 10, 17, 21, 30)},
+		{"today at 5pm", at(2026, 10, 16, 17, 0)},
+		{"next friday at 18:00", at(2026, 10, 23, 18, 0)},
+		{"friday 18:00", at(2026, 10, 16, 18, 0)},
+		{"friday 9am", at(2026, 10, 23, 9, 0)},
+		{"fri", at(2026, 10, 23, 9, 0)},
+		{"monday", at(2026, 10, 19, 9, 0)},
+		{"next monday", at(2026, 10, 19, 9, 0)},
+		{"this sunday 10:30pm", at(2026, 10, 18, 22, 30)},
+		{"on thursday at midday", at(2026, 10, 22, 12, 0)},
+		{"2026-12-25 10:00", at(2026, 12, 25, 10, 0)},
+		{"10:00 2026-12-25", at(2026, 12, 25, 10, 0)},
+		{"25 december", at(2026, 12, 25, 9, 0)},
+		{"dec 25th at 8am", at(2026, 12, 25, 8, 0)},
+		{"march 3", at(2027, 3, 3, 9, 0)},
+		{"1 jan 2030 noon", at(2030, 1, 1, 12, 0)},
+		{"September 9, 2027", at(2027, 9, 9, 9, 0)},
+		{"29 feb 2028", at(2028, 2, 29, 9, 0)},
+	}
+	for _, test := range tests {
+		got, err := parseWhen(test.input, now)
+		if err != nil {
+			t.Errorf("parseWhen(%q) failed: %v", test.input, err)
+		} else if !got.Equal(test.want) {
+			t.Errorf("parseWhen(%q) = %v, want %v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestParseWhenRejects(t *testing.T) {
+	now := time.Date(2026, 10, 16, 14, 30, 0, 0, time.UTC)
+	for _, input := range []string{
+		"",
+		"   ",
+		"whenever",
+		"in 5 parsecs",
+		"in a bit",
+		"0m",
+		"5d3h30",
+		"25:00",
+		"13pm",
+		"9:75",
+		"18",
+		"tomorrow tomorrow",
+		"tomorrow friday",
+		"9am 10am",
+		"next",
+		"next 9am",
+		"2026-02-30",
+		"31 september",
+		"2020-01-01 10:00",
+		"2026-10-16",
+		"the 1st of",
+		"today 9am",
+		"december",
+		"99999999999999999999h",
+	} {
+		if got, err := parseWhen(input, now); err == nil {
+			t.Errorf("parseWhen(%q) = %v, want an error", input, got)
+		}
+	}
+}
+
+func TestParseWhenKeepsLocation(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skipf("No time zone data: %v", err)
+	}
+	// The clocks go back overnight, so tomorrow 9am is 25 hours away but still 9am
+	now := time.Date(2026, 10, 24, 9, 0, 0, 0, london)
+	got, err := parseWhen("tomorrow 9am", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 10, 25, 9, 0, 0, 0, london); !got.Equal(want) || got.Sub(now) != 25*time.Hour {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+	if got, _ := parseWhen("1d", now); got.Hour() != 9 {
+		t.Errorf("1d is %v, want the same time tomorrow", got)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	// Bundle the zone database so picking a zone works on hosts without one
+	_ "time/tzdata"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// clockFormat is how the current time is shown to someone in their own zone
+const clockFormat = "15:04 MST on Monday 2 January"
+
+// findTimezone loads a zone by its IANA name, forgiving case and spaces for underscores
+func findTimezone(name string) (*time.Location, error) {
+	name = strings.Replace(strings.TrimSpace(name), " ", "_", -1)
+	for _, known := range timezoneNames {
+		if strings.EqualFold(known, name) {
+			name = known
+			break
+		}
+	}
+	// LoadLocation would take these as UTC and wherever the bot's running
+	if name == "" || name == "Local" {
+		return nil, errors.New("no such time zone")
+	}
+	return time.LoadLocation(name)
+}
+
+// userLocation gets the time zone someone's picked, or UTC if they haven't or it can't be
+// looked up
+func userLocation(ctx context.Context, s botSession, i *discordgo.InteractionCreate) *time.Location {
+	user := interactionUser(i)
+	if db == nil || user == nil {
+		return time.UTC
+	}
+	loc, err := savedLocation(ctx, user.ID)
+	if err != nil {
+		report(s, i, err)
+	}
+	return loc
+}
+
+// savedLocation gets the time zone a user's picked, or UTC if they haven't. If it can't be
+// looked up, it's UTC along with the error
+func savedLocation(ctx context.Context, userID string) (*time.Location, error) {
+	zone, err := db.UserTimezone(ctx, userID)
+	if err == errNotFound {
+		return time.UTC, nil
+	}
+	if err != nil {
+		return time.UTC, fmt.Errorf("getting time zone: %v", err)
+	}
+	loc, err := findTimezone(zone)
+	if err != nil {
+		return time.UTC, fmt.Errorf("loading time zone %v: %v", zone, err)
+	}
+	return loc, nil
+}
+
+// timezoneChoices suggests zones matching what's been typed so far, putting zones where a
+// part of the name starts with it ahead of ones that just contain it
+func timezoneChoices(typed string) []*discordgo.ApplicationCommandOptionChoice {
+	typed = strings.ToLower(strings.Replace(strings.TrimSpace(typed), " ", "_", -1))
+	var starts, contains []string
+	for _, name := range timezoneNames {
+		lower := strings.ToLower(name)
+		switch {
+		case strings.HasPrefix(lower, typed) || strings.Contains(lower, "/"+typed):
+			starts = append(starts, name)
+		case strings.Contains(lower, typed):
+			contains = append(contains, name)
+		}
+	}
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+	for _, name := range append(starts, contains...) {
+		if len(choices) == 25 {
+			break
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: strings.Replace(name, "_", " ", -1), Value: name})
+	}
+	return choices
+}
+
+var timezoneCommand = &command{
+	Name:        "timezone",
+	Description: "Pick the time zone I use for your reminders, music days and times",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "set",
+			Description: "Pick your time zone",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "zone",
+					Description:  "Where you are, like Europe/London or America/New_York",
+					Required:     true,
+					Autocomplete: true,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "show",
+			Description: "See which time zone I'm using for you",
+		},
+	},
+	Handler: timezoneHandler,
+	Autocomplete: func(ctx context.Context, i *discordgo.InteractionCreate, opts commandOptions) []*discordgo.ApplicationCommandOptionChoice {
+		return timezoneChoices(opts.String("zone"))
+	},
+}
+
+func timezoneHandler(ctx context.Context, s botSession, i *discordgo.InteractionCreate, opts commandOptions) {
+	respond := func(content string) {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags:   64,
+				Content: content,
+			},
+		})
+	}
+	if db == nil {
+		respond("I haven't been set up to remember time zones, please moan at whoever set me up")
+		return
+	}
+
+	if opts.Subcommand() == "show" {
+		zone, err := db.UserTimezone(ctx, i.Member.User.ID)
+		if err == errNotFound {
+			respond("You haven't picked a time zone, so I'm using UTC. Pick one with /timezone set")
+			return
+		}
+		if err != nil {
+			respond("Something went wrong at my end so I couldn't look up your time zone")
+			report(s, i, fmt.Errorf("getting time zone: %v", err))
+			return
+		}
+		loc := userLocation(ctx, s, i)
+		respond("Your time zone is " + zone + ", where it's " + time.Now().In(loc).Format(clockFormat))
+		return
+	}
+
+	loc, err := findTimezone(opts.String("zone"))
+	if err != nil {
+		respond("I don't know a time zone called " + opts.String("zone") + ". Pick one from the list, like Europe/London")
+		return
+	}
+	if err := db.SaveUserTimezone(ctx, i.Member.User.ID, loc.String()); err != nil {
+		respond("Something went wrong at my end so I didn't save your time zone")
+		report(s, i, fmt.Errorf("saving time zone: %v", err))
+		return
+	}
+	respond("Okay, I'll use " + loc.String() + " for your times from now on")
+}
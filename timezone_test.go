@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func slashCommand(name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		Type:    discordgo.InteractionApplicationCommand,
+		GuildID: "1",
+		Member:  &discordgo.Member{User: &discordgo.User{ID: "100", Username: "banjo"}},
+		Data:    discordgo.ApplicationCommandInteractionData{Name: name, Options: options},
+	}}
+}
+
+func stringOption(name, value string) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionString, Value: value}
+}
+
+func TestTimezoneChoices(t *testing.T) {
+	choices := timezoneChoices("lond")
+	if len(choices) == 0 || choices[0].Value != "Europe/London" || choices[0].Name != "Europe/London" {
+		t.Errorf("Got %+v for lond, want Europe/London first", choices)
+	}
+	choices = timezoneChoices("new york")
+	if len(choices) != 1 || choices[0].Value != "America/New_York" || choices[0].Name != "America/New York" {
+		t.Errorf("Got %+v for new york", choices)
+	}
+	if choices := timezoneChoices(""); len(choices) != 25 {
+		t.Errorf("Got %d choices for nothing typed, want 25", len(choices))
+	}
+	if choices := timezoneChoices("nowhere at all"); len(choices) != 0 {
+		t.Errorf("Got %+v for nowhere at all", choices)
+	}
+	for _, name := range timezoneNames {
+		if _, err := findTimezone(name); err != nil {
+			t.Errorf("Offered %v, which doesn't load: %v", name, err)
+		}
+	}
+}
+
+func TestTimezoneAppliesToReminders(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	handleInteraction(ctx, newFakeSession(), slashCommand("timezone", &discordgo.ApplicationCommandInteractionDataOption{
+		Name:    "set",
+		Type:    discordgo.ApplicationCommandOptionSubCommand,
+		Options: []*discordgo.ApplicationCommandInteractionDataOption{stringOption("zone", "Europe/Paris")},
+	}))
+
+	fake := newFakeSession()
+	handleInteraction(ctx, fake, slashCommand("reminder", stringOption("reminder", "feed the bird"), stringOption("when", "2099-12-25 10:00")))
+	want := "Okay, I'll remind you of feed the bird on Friday 25 December 2099 at 10:00 CET (<t:4101872400:R>)"
+	if len(fake.responses) != 1 || fake.responses[0].Data.Content != want {
+		t.Fatalf("Got responses %+v, want %q", fake.responses, want)
+	}
+	reminders, err := db.DueReminders(ctx, time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reminders) != 1 || reminders[0].Date.Unix() != 4101872400 {
+		t.Errorf("Saved %+v, want it due at 09:00 UTC", reminders)
+	}
+
+	fake = newFakeSession()
+	handleInteraction(ctx, fake, slashCommand("timezone", &discordgo.ApplicationCommandInteractionDataOption{Name: "show", Type: discordgo.ApplicationCommandOptionSubCommand}))
+	if len(fake.responses) != 1 || !strings.HasPrefix(fake.responses[0].Data.Content, "Your time zone is Europe/Paris, where it's ") {
+		t.Errorf("Got responses %+v", fake.responses)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+	"google.golang.org/api/youtube/v3"
+)
+
+const youtubeScope = "https://www.googleapis.com/auth/youtubepartner"
+
+// youtubeTokenSetting is where the encrypted YouTube login lives in the store
+const youtubeTokenSetting = "youtubeToken"
+
+func youtubeOAuthConfig() (*oauth2.Config, error) {
+	data, err := ioutil.ReadFile(conf.YouTube.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	return google.ConfigFromJSON(data, youtubeScope)
+}
+
+// tokenCipher derives an AES key from youtube.token_key, so any length of passphrase will do
+func tokenCipher(key string) (cipher.AEAD, error) {
+	if key == "" {
+		return nil, errors.New("youtube.token_key isn't set")
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealToken encrypts a token, putting the nonce in front of the ciphertext
+func sealToken(key string, token *oauth2.Token) ([]byte, error) {
+	aead, err := tokenCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := json.Marshal(token)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+func openToken(key string, sealed []byte) (*oauth2.Token, error) {
+	aead, err := tokenCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("saved YouTube login is corrupt")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("couldn't decrypt the saved YouTube login; has youtube.token_key changed?")
+	}
+	var token oauth2.Token
+	if err := json.Unmarshal(plain, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func saveYouTubeToken(ctx context.Context, token *oauth2.Token) error {
+	sealed, err := sealToken(conf.YouTube.TokenKey, token)
+	if err != nil {
+		return err
+	}
+	return db.SaveSetting(ctx, youtubeTokenSetting, sealed)
+}
+
+// savingTokenSource saves the token whenever Google hands out a new one, so a refreshed
+// login survives restarts
+type savingTokenSource struct {
+	mu   sync.Mutex
+	base oauth2.TokenSource
+	last *oauth2.Token
+}
+
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.base.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last == nil || token.AccessToken != s.last.AccessToken || token.RefreshToken != s.last.RefreshToken {
+		// Carry on with the new token even if it can't be saved; it'll be retried on the next refresh
+		if err := saveYouTubeToken(context.Background(), token); err != nil {
+			log.Printf("Couldn't save refreshed YouTube login: %v", err)
+		} else {
+			s.last = token
+		}
+	}
+	return token, nil
+}
+
+// connectYouTube sets up youtubeClient from the login saved by youtube-auth
+func connectYouTube(ctx context.Context) error {
+	oauthConfig, err := youtubeOAuthConfig()
+	if err != nil {
+		return fmt.Errorf("couldn't find or decode %v: %v", conf.YouTube.ClientSecret, err)
+	}
+	sealed, err := db.Setting(ctx, youtubeTokenSetting)
+	if err == errNotFound {
+		return errors.New("not logged in, run kazooiebot youtube-auth")
+	}
+	if err != nil {
+		return err
+	}
+	token, err := openToken(conf.YouTube.TokenKey, sealed)
+	if err != nil {
+		return err
+	}
+	source := &savingTokenSource{base: oauthConfig.TokenSource(ctx, token), last: token}
+	youtubeClient, err = youtube.NewService(ctx, option.WithTokenSource(source))
+	return err
+}
+
+// callbackCode gets the auth code from the query Google redirects back with
+func callbackCode(query url.Values, state string) (string, error) {
+	if query.Get("state") != state {
+		return "", errors.New("that login wasn't started by this run of youtube-auth")
+	}
+	if reason := query.Get("error"); reason != "" {
+		return "", fmt.Errorf("Google refused the login: %v", reason)
+	}
+	if query.Get("code") == "" {
+		return "", errors.New("Google didn't send back a code")
+	}
+	return query.Get("code"), nil
+}
+
+// waitForCode runs a local callback server for Google to redirect to. If the browser is on
+// another machine it can't reach that server, so the address it ends up on can be pasted in instead
+func waitForCode(oauthConfig *oauth2.Config) (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	oauthConfig.RedirectURL = "http://" + listener.Addr().String() + "/"
+
+	nonce := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	state := hex.EncodeToString(nonce)
+
+	type result struct {
+		code string
+		err  error
+	}
+	results := make(chan result, 2)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code, err := callbackCode(r.URL.Query(), state)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintln(w, "Logged in, you can close this tab")
+		select {
+		case results <- result{code: code}:
+		default:
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			pasted, err := url.Parse(strings.TrimSpace(scanner.Text()))
+			if err != nil {
+				fmt.Println("That isn't an address, try again")
+				continue
+			}
+			code, err := callbackCode(pasted.Query(), state)
+			results <- result{code: code, err: err}
+			return
+		}
+	}()
+
+	fmt.Printf("Visit this URL to log the bot into YouTube:\n\n%v\n\n", oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce))
+	fmt.Println("If your browser is on another machine, the page it ends up on won't load; paste that page's address here instead.")
+	r := <-results
+	return r.code, r.err
+}
+
+// youtubeAuth logs the bot into YouTube and saves the login, for the youtube-auth subcommand
+func youtubeAuth() {
+	ctx := context.Background()
+	if _, err := tokenCipher(conf.YouTube.TokenKey); err != nil {
+		log.Fatalf("Can't save a YouTube login: %v", err)
+	}
+	oauthConfig, err := youtubeOAuthConfig()
+	if err != nil {
+		log.Fatalf("Couldn't find or decode %v: %v", conf.YouTube.ClientSecret, err)
+	}
+	db, err = openStore(ctx)
+	if err != nil {
+		log.Fatalf("Couldn't open %v storage: %v", conf.Storage.Backend, err)
+	}
+	defer db.Close()
+
+	code, err := waitForCode(oauthConfig)
+	if err != nil {
+		log.Fatalf("Couldn't log in to YouTube: %v", err)
+	}
+	token, err := oauthConfig.Exchange(ctx, code)
+	if err != nil {
+		log.Fatalf("Couldn't log in to YouTube: %v", err)
+	}
+	if token.RefreshToken == "" {
+		log.Fatalf("Google didn't send a refresh token, so the login would only last an hour")
+	}
+	if err := saveYouTubeToken(ctx, token); err != nil {
+		log.Fatalf("Couldn't save the YouTube login: %v", err)
+	}
+	log.Println("Saved the YouTube login")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestSealToken(t *testing.T) {
+	token := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)}
+	sealed, err := sealToken("hunter2", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened, err := openToken("hunter2", sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.AccessToken != token.AccessToken || opened.RefreshToken != token.RefreshToken || !opened.Expiry.Equal(token.Expiry) {
+		t.Errorf("Got %+v back, want %+v", opened, token)
+	}
+
+	if _, err := openToken("hunter3", sealed); err == nil {
+		t.Error("Opened a token with the wrong key")
+	}
+	if _, err := sealToken("", token); err == nil {
+		t.Error("Sealed a token without a key")
+	}
+}
+
+// staticSource hands out whatever token it's been given
+type staticSource struct {
+	token *oauth2.Token
+}
+
+func (s *staticSource) Token() (*oauth2.Token, error) {
+	return s.token, nil
+}
+
+func TestSavingTokenSourceSavesRefreshes(t *testing.T) {
+	useTestBot(t)
+	ctx := context.Background()
+	conf.YouTube.TokenKey = "hunter2"
+	first := &oauth2.Token{AccessToken: "first", RefreshToken: "refresh"}
+	base := &staticSource{token: first}
+	source := &savingTokenSource{base: base, last: first}
+
+	if _, err := source.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Setting(ctx, youtubeTokenSetting); err != errNotFound {
+		t.Errorf("Saved a token that hadn't changed: %v", err)
+	}
+
+	base.token = &oauth2.Token{AccessToken: "second", RefreshToken: "refresh"}
+	if _, err := source.Token(); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := db.Setting(ctx, youtubeTokenSetting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := openToken("hunter2", sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.AccessToken != "second" {
+		t.Errorf("Saved %+v, want the refreshed token", saved)
+	}
+}